@@ -2,14 +2,845 @@ package json5
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func NewDecoder(rd io.Reader) *json.Decoder {
-	return json.NewDecoder(NewReader(rd))
+// Decoder reads and decodes JSON5 values from an input stream.
+//
+// It embeds a json.Decoder reading from the translated JSON, so all of its
+// methods are available; Decode additionally honors the decoding functions
+// registered with RegisterDecoder.
+type Decoder struct {
+	*json.Decoder
+	rd       *Reader
+	decoders map[reflect.Type]func([]byte, reflect.Value) error
+	custom   map[reflect.Type]bool
+	patterns map[string]*regexp.Regexp
+	version  *int64
+	warnings []Warning
+	spans    map[string]Span
+
+	useNumber             bool
+	disallowUnknownFields bool
+}
+
+func NewDecoder(rd io.Reader, opts ...Option) *Decoder {
+	r := NewReader(rd, opts...)
+	r.TrackPositions()
+	return &Decoder{Decoder: json.NewDecoder(r), rd: r}
+}
+
+// NewSafeDecoder returns a decoder suitable for untrusted input, such as
+// request bodies. It bounds the nesting depth, input size, keys per object
+// and token length, and rejects duplicate keys. The defaults are
+// conservative; opts are applied after them and may relax them.
+//
+// Infinity and NaN are never accepted, since they have no JSON equivalent.
+func NewSafeDecoder(rd io.Reader, opts ...Option) *Decoder {
+	safe := []Option{
+		WithMaxDepth(64),
+		WithMaxSize(1 << 20),
+		WithMaxKeys(1024),
+		WithMaxTokenLength(64 << 10),
+		WithRejectDuplicateKeys(),
+	}
+	return NewDecoder(rd, append(safe, opts...)...)
 }
 
 func Unmarshal(data []byte, v interface{}) error {
 	return NewDecoder(bytes.NewReader(data)).Decode(v)
 }
+
+// RegisterDecoder registers fn as the decoding function for all values of
+// type t read by this decoder, including values nested in structs, slices,
+// arrays and maps.
+//
+// fn is given the JSON translation of the value and a settable reflect.Value
+// of type t. Registered functions take precedence over UnmarshalJSON and
+// UnmarshalText methods, which makes it possible to decode types whose
+// methods cannot be changed.
+func (d *Decoder) RegisterDecoder(t reflect.Type, fn func([]byte, reflect.Value) error) {
+	if d.decoders == nil {
+		d.decoders = make(map[reflect.Type]func([]byte, reflect.Value) error)
+	}
+	d.decoders[t] = fn
+	d.custom = nil
+}
+
+// UseNumber causes the Decoder to unmarshal a number into an interface{} as
+// a json.Number instead of as a float64.
+func (d *Decoder) UseNumber() {
+	d.useNumber = true
+	d.Decoder.UseNumber()
+}
+
+// Token is like json.Decoder.Token, and additionally drops the positions
+// of the source that are no longer needed.
+func (d *Decoder) Token() (json.Token, error) {
+	d.rd.forget(d.InputOffset())
+	return d.Decoder.Token()
+}
+
+// DisallowUnknownFields causes the Decoder to return an error when the
+// destination is a struct and the input contains object keys which do not
+// match any non-ignored, exported fields in the destination.
+func (d *Decoder) DisallowUnknownFields() {
+	d.disallowUnknownFields = true
+	d.Decoder.DisallowUnknownFields()
+}
+
+// SetMaxStringBytes limits the total size in bytes of the contents of all
+// strings and unquoted keys in the input. See Reader.SetMaxStringBytes.
+func (d *Decoder) SetMaxStringBytes(n int) {
+	d.rd.SetMaxStringBytes(n)
+}
+
+// Decode reads the next JSON5 value from its input and stores it in the
+// value pointed to by v.
+//
+// Fields are named by their json tag as described for encoding/json, unless
+// they have a json5 tag naming them: a field tagged json5:"-" is ignored
+// whatever its json tag, and one tagged json5:"name" is named name, without
+// the options of its json tag. The string option of json tags is honored as
+// in encoding/json; the other options only matter when encoding. A json5 tag
+// without a name, such as json5:",trim", only adds options to the json tag.
+// json5 tags support the following options:
+//
+//   - pattern=REGEXP: the string value must match the regular expression.
+//     As it may contain commas, it must be the last option of the tag.
+//   - since=N: the field only exists since version N of the schema; see
+//     WithVersionKey.
+//   - epoch=UNIT: the time.Time field is decoded from an integer number of
+//     seconds (s), milliseconds (ms) or nanoseconds (ns) since the Unix
+//     epoch.
+//   - trim: leading and trailing whitespace is removed from the string.
+//   - collapse: like trim, and each inner run of whitespace is replaced by
+//     a single space.
+func (d *Decoder) Decode(v interface{}) error {
+	// Positions are only needed for the value about to be decoded.
+	d.rd.forget(d.InputOffset())
+	d.warnings = nil
+	d.spans = nil
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || !d.rd.opts.spans && !d.isCustom(rv.Type().Elem()) {
+		// Positions are not needed for this value, but may be for those
+		// read ahead.
+		d.rd.discard = true
+		defer func() {
+			d.rd.discard = false
+			d.rd.forget(d.InputOffset())
+		}()
+		return d.Decoder.Decode(v)
+	}
+
+	var data json.RawMessage
+	if err := d.Decoder.Decode(&data); err != nil {
+		return err
+	}
+	val := raw{data: data, off: d.InputOffset() - int64(len(data))}
+	if d.rd.opts.spans {
+		d.spans = make(map[string]Span)
+		if err := d.recordSpans(val, ""); err != nil {
+			return err
+		}
+	}
+	d.patterns = nil
+	if err := d.readVersion(val); err != nil {
+		return err
+	}
+	return d.decode(val, rv.Elem())
+}
+
+// readVersion looks up the schema version declared by the document val,
+// as designated by WithVersionKey.
+func (d *Decoder) readVersion(val raw) error {
+	d.version = nil
+	key := d.rd.opts.versionKey
+	if key == "" || len(val.data) == 0 || val.data[0] != '{' {
+		return nil
+	}
+	members, err := val.members()
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.key != key {
+			continue
+		}
+		version, err := strconv.ParseInt(string(m.val.data), 10, 64)
+		if err != nil {
+			return d.errorAt(m.val.off, fmt.Errorf("version %s is not an integer", m.val.data))
+		}
+		d.version = &version
+	}
+	return nil
+}
+
+// Spans returns where each object member of the last decoded value is
+// defined in the source, from the start of its key to the end of its value.
+// Members are identified by their path from the decoded value, such as
+// "servers[1].host". It requires WithSpans.
+func (d *Decoder) Spans() map[string]Span {
+	return d.spans
+}
+
+// recordSpans records the spans of the members of val and of its nested
+// values, with path leading to val.
+func (d *Decoder) recordSpans(val raw, path string) error {
+	switch {
+	case len(val.data) > 0 && val.data[0] == '{':
+		members, err := val.members()
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			p := m.key
+			if path != "" {
+				p = path + "." + m.key
+			}
+			d.spans[p] = Span{
+				Start: d.rd.Position(m.keyOff),
+				End:   d.rd.positionAfter(m.val.off + int64(len(m.val.data))),
+			}
+			if err := d.recordSpans(m.val, p); err != nil {
+				return err
+			}
+		}
+	case len(val.data) > 0 && val.data[0] == '[':
+		elems, err := val.elements()
+		if err != nil {
+			return err
+		}
+		for i, elem := range elems {
+			if err := d.recordSpans(elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Warnings returns the warnings raised while decoding the last value.
+func (d *Decoder) Warnings() []Warning {
+	return d.warnings
+}
+
+// warn records a warning positioned at the source of the JSON value
+// starting at offset.
+func (d *Decoder) warn(offset int64, format string, args ...interface{}) {
+	pos := d.rd.Position(offset)
+	d.warnings = append(d.warnings, Warning{Line: pos.Line, Column: pos.Column, Message: fmt.Sprintf(format, args...)})
+}
+
+// errorAt returns err as a DecodeError positioned at the source of the
+// JSON value starting at offset.
+func (d *Decoder) errorAt(offset int64, err error) error {
+	pos := d.rd.Position(offset)
+	return &DecodeError{Line: pos.Line, Column: pos.Column, Err: err}
+}
+
+// isCustom returns whether values of type t cannot be handed over as-is to
+// encoding/json, because t is or contains a type with a registered decoder,
+// a struct with json5 tags or whose keys may be aliased, a map whose
+// entries are validated, or an interface{} that may hold integers.
+func (d *Decoder) isCustom(t reflect.Type) bool {
+	if d.custom == nil {
+		d.custom = make(map[reflect.Type]bool)
+	}
+	custom, _ := d.customType(t, map[reflect.Type]int{})
+	return custom
+}
+
+// customType computes isCustom for t. visiting holds the depth of the types
+// whose answer is being computed: reaching one of them again breaks the
+// cycle with a provisional false. The answer for t is only stored once it no
+// longer depends on such an answer for a type further up, whose depth is
+// returned as pending, or -1.
+func (d *Decoder) customType(t reflect.Type, visiting map[reflect.Type]int) (custom bool, pending int) {
+	if custom, ok := d.custom[t]; ok {
+		return custom, -1
+	}
+	if depth, ok := visiting[t]; ok {
+		return false, depth
+	}
+	depth := len(visiting)
+	visiting[t] = depth
+	defer delete(visiting, t)
+
+	pending = -1
+	elem := func(t reflect.Type) bool {
+		custom, p := d.customType(t, visiting)
+		if p != -1 && (pending == -1 || p < pending) {
+			pending = p
+		}
+		return custom
+	}
+	switch {
+	case d.decoders[t] != nil, t == urlType:
+		custom = true
+	case implementsUnmarshaler(t):
+	default:
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array:
+			custom = elem(t.Elem())
+		case reflect.Map:
+			custom = d.rd.opts.entryValidator != nil || elem(t.Elem())
+		case reflect.Interface:
+			custom = d.rd.opts.integers && t.NumMethod() == 0
+		case reflect.Struct:
+			custom = len(d.rd.opts.keyAliases) > 0
+			for _, f := range cachedFields(t) {
+				if f.json5 || elem(f.typ) {
+					custom = true
+					break
+				}
+			}
+		}
+	}
+	if custom || pending >= depth {
+		pending = -1
+	}
+	if pending == -1 {
+		d.custom[t] = custom
+	}
+	return custom, pending
+}
+
+func (d *Decoder) decode(val raw, v reflect.Value) error {
+	if fn := d.decoders[v.Type()]; fn != nil {
+		return fn(val.data, v)
+	}
+	if v.Type() == urlType {
+		return d.decodeURL(val, v)
+	}
+	if !d.isCustom(v.Type()) {
+		return d.unmarshal(val.data, v)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if val.isNull() {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(val, v.Elem())
+	case reflect.Struct:
+		return d.decodeStruct(val, v)
+	case reflect.Slice, reflect.Array:
+		return d.decodeArray(val, v)
+	case reflect.Map:
+		return d.decodeMap(val, v)
+	case reflect.Interface:
+		return d.decodeInterface(val, v)
+	}
+	return d.unmarshal(val.data, v)
+}
+
+// decodeInterface decodes val into the empty interface v, storing integral
+// numbers as integers.
+func (d *Decoder) decodeInterface(val raw, v reflect.Value) error {
+	dec := json.NewDecoder(bytes.NewReader(val.data))
+	dec.UseNumber()
+	var x interface{}
+	if err := dec.Decode(&x); err != nil {
+		return err
+	}
+	x = d.fitNumbers(x)
+	if x == nil {
+		v.Set(reflect.Zero(v.Type()))
+	} else {
+		v.Set(reflect.ValueOf(x))
+	}
+	return nil
+}
+
+// fitNumbers replaces the json.Numbers in x with int64, uint64 or float64
+// values, as documented by WithIntegers.
+func (d *Decoder) fitNumbers(x interface{}) interface{} {
+	switch x := x.(type) {
+	case json.Number:
+		s := string(x)
+		if !strings.ContainsAny(s, ".eE") {
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return n
+			}
+			if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+				return n
+			}
+		}
+		if d.useNumber {
+			return x
+		}
+		f, _ := strconv.ParseFloat(s, 64)
+		return f
+	case map[string]interface{}:
+		for k, v := range x {
+			x[k] = d.fitNumbers(v)
+		}
+	case []interface{}:
+		for i, v := range x {
+			x[i] = d.fitNumbers(v)
+		}
+	}
+	return x
+}
+
+func (d *Decoder) unmarshal(data []byte, v reflect.Value) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if d.useNumber {
+		dec.UseNumber()
+	}
+	if d.disallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if v.CanAddr() {
+		return dec.Decode(v.Addr().Interface())
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	if err := dec.Decode(ptr.Interface()); err != nil {
+		return err
+	}
+	v.Set(ptr.Elem())
+	return nil
+}
+
+func (d *Decoder) decodeStruct(val raw, v reflect.Value) error {
+	if val.isNull() {
+		return nil
+	}
+	members, err := val.members()
+	if err != nil {
+		return err
+	}
+	fields := cachedFields(v.Type())
+	for i := range fields {
+		if err := d.compilePatterns(&fields[i]); err != nil {
+			return err
+		}
+	}
+	// Like encoding/json, a value of the wrong type does not stop decoding
+	// the other members, but the first one is reported.
+	var typeErr error
+	for _, m := range members {
+		if alias, ok := d.rd.opts.keyAliases[m.key]; ok {
+			d.warn(m.keyOff, "key %q is deprecated, use %q instead", m.key, alias)
+			m.key = alias
+		}
+		f := fields.lookup(m.key)
+		if f == nil {
+			if d.disallowUnknownFields {
+				return d.errorAt(m.keyOff, fmt.Errorf("unknown field %q", m.key))
+			}
+			continue
+		}
+		if err := d.checkVersion(f, m); err != nil {
+			return err
+		}
+		fv, err := fieldByIndex(v, f.index)
+		if err != nil {
+			return d.errorAt(m.keyOff, err)
+		}
+		if err := d.decodeField(f, m.val, fv); err != nil {
+			var ute *json.UnmarshalTypeError
+			if !errors.As(err, &ute) {
+				return err
+			}
+			if _, ok := err.(*DecodeError); ok {
+				// Positioned by a nested struct, which made the copy.
+				inField(ute, v.Type(), f.name)
+			} else {
+				err = d.errorAt(m.val.off, fieldTypeError(ute, v.Type(), f.name))
+			}
+			if typeErr == nil {
+				typeErr = err
+			}
+			continue
+		}
+		if err := d.validate(f, m.val, fv); err != nil {
+			return err
+		}
+	}
+	return typeErr
+}
+
+// fieldTypeError returns err, raised while decoding field name of the struct
+// type t, with the field context that encoding/json would give it.
+func fieldTypeError(err *json.UnmarshalTypeError, t reflect.Type, name string) error {
+	e := *err
+	inField(&e, t, name)
+	return &e
+}
+
+// inField makes err relative to the struct type t, of which it concerns the
+// field name.
+func inField(err *json.UnmarshalTypeError, t reflect.Type, name string) {
+	err.Struct = t.Name()
+	if err.Field == "" {
+		err.Field = name
+	} else {
+		err.Field = name + "." + err.Field
+	}
+}
+
+// decodeField decodes val into the value v of field f, honoring the tag
+// options that change how values are decoded.
+func (d *Decoder) decodeField(f *field, val raw, v reflect.Value) error {
+	if unit, ok := f.opts.Get("epoch"); ok {
+		return d.decodeEpoch(f, unit, val, v)
+	}
+	if f.quoted && !val.isNull() {
+		// The string option of the json tag quotes the value in a string.
+		invalid := func() error {
+			return d.errorAt(val.off, fmt.Errorf("invalid use of ,string struct tag, trying to unmarshal %s into %v", val.data, v.Type()))
+		}
+		var s string
+		if err := json.Unmarshal(val.data, &s); err != nil {
+			return invalid()
+		}
+		err := d.decode(raw{data: []byte(s), off: val.off}, v)
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return invalid()
+		}
+		if err != nil {
+			return err
+		}
+	} else if err := d.decode(val, v); err != nil {
+		return err
+	}
+
+	_, trim := f.opts.Get("trim")
+	_, collapse := f.opts.Get("collapse")
+	if !trim && !collapse {
+		return nil
+	}
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.Ptr:
+		// null values have no whitespace
+	case v.Kind() != reflect.String:
+		return fmt.Errorf("json5: trim and collapse options of field %v apply to strings, not %v", f.name, v.Type())
+	case collapse:
+		v.SetString(strings.Join(strings.Fields(v.String()), " "))
+	default:
+		v.SetString(strings.TrimSpace(v.String()))
+	}
+	return nil
+}
+
+// decodeEpoch decodes a number of units since the Unix epoch into the
+// time.Time or *time.Time value v of field f.
+func (d *Decoder) decodeEpoch(f *field, unit string, val raw, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if val.isNull() {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if v.Type() != timeType {
+		return fmt.Errorf("json5: epoch option of field %v applies to time.Time, not %v", f.name, v.Type())
+	}
+	if val.isNull() {
+		return nil
+	}
+
+	n, err := strconv.ParseInt(string(val.data), 10, 64)
+	if err != nil {
+		return d.errorAt(val.off, fmt.Errorf("epoch timestamp %s of field %v is not an integer", val.data, f.name))
+	}
+	var t time.Time
+	switch unit {
+	case "s":
+		t = time.Unix(n, 0)
+	case "ms":
+		t = time.UnixMilli(n)
+	case "ns":
+		t = time.Unix(0, n)
+	default:
+		return fmt.Errorf("json5: invalid epoch unit %q on field %v", unit, f.name)
+	}
+	v.Set(reflect.ValueOf(t))
+	return nil
+}
+
+// checkVersion rejects the member m for field f if the field appeared in a
+// later schema version than the one declared by the document.
+func (d *Decoder) checkVersion(f *field, m member) error {
+	opt, ok := f.opts.Get("since")
+	if !ok || d.version == nil {
+		return nil
+	}
+	since, err := strconv.ParseInt(opt, 10, 64)
+	if err != nil {
+		return fmt.Errorf("json5: invalid since option on field %v: %w", f.name, err)
+	}
+	if *d.version < since {
+		return d.errorAt(m.keyOff, fmt.Errorf("field %v requires version %d, but the document declares version %d", f.name, since, *d.version))
+	}
+	return nil
+}
+
+// compilePatterns compiles the regular expression of the pattern option of
+// f, once per call to Decode.
+func (d *Decoder) compilePatterns(f *field) error {
+	expr, ok := f.opts.Get("pattern")
+	if !ok || d.patterns[expr] != nil {
+		return nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("json5: invalid pattern on field %v: %w", f.name, err)
+	}
+	if d.patterns == nil {
+		d.patterns = make(map[string]*regexp.Regexp)
+	}
+	d.patterns[expr] = re
+	return nil
+}
+
+// validate checks the decoded value v of field f against the constraints
+// of its tag options.
+func (d *Decoder) validate(f *field, val raw, v reflect.Value) error {
+	if expr, ok := f.opts.Get("pattern"); ok {
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		switch {
+		case v.Kind() == reflect.Ptr:
+			// null values have nothing to match
+		case v.Kind() != reflect.String:
+			return d.errorAt(val.off, fmt.Errorf("pattern option of field %v applies to strings, not %v", f.name, v.Type()))
+		case !d.patterns[expr].MatchString(v.String()):
+			return d.errorAt(val.off, fmt.Errorf("value %q of field %v does not match pattern %q", v.String(), f.name, expr))
+		}
+	}
+	return nil
+}
+
+// decodeURL decodes a string into a url.URL. It implements neither
+// json.Unmarshaler nor encoding.TextUnmarshaler, which makes it unusable
+// with encoding/json otherwise.
+func (d *Decoder) decodeURL(val raw, v reflect.Value) error {
+	if val.isNull() {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(val.data, &s); err != nil {
+		return d.errorAt(val.off, fmt.Errorf("cannot decode %s into a URL", val.data))
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return d.errorAt(val.off, err)
+	}
+	v.Set(reflect.ValueOf(*u))
+	return nil
+}
+
+func (d *Decoder) decodeArray(val raw, v reflect.Value) error {
+	if val.isNull() {
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	elems, err := val.elements()
+	if err != nil {
+		return err
+	}
+	if v.Kind() == reflect.Slice {
+		v.Set(reflect.MakeSlice(v.Type(), len(elems), len(elems)))
+	}
+	for i := 0; i < v.Len(); i++ {
+		if i >= len(elems) {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+			continue
+		}
+		if err := d.decode(elems[i], v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) decodeMap(val raw, v reflect.Value) error {
+	if val.isNull() {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	members, err := val.members()
+	if err != nil {
+		return err
+	}
+	t := v.Type()
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(t))
+	}
+	for _, m := range members {
+		key, err := mapKey(t.Key(), m.key)
+		if err != nil {
+			return d.errorAt(m.keyOff, err)
+		}
+		elem := reflect.New(t.Elem()).Elem()
+		if err := d.decode(m.val, elem); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+		if fn := d.rd.opts.entryValidator; fn != nil {
+			if err := fn(m.key, elem, d.rd.Position(m.keyOff)); err != nil {
+				return d.errorAt(m.keyOff, err)
+			}
+		}
+	}
+	return nil
+}
+
+func mapKey(t reflect.Type, key string) (reflect.Value, error) {
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		kv := reflect.New(t)
+		if err := kv.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(key)); err != nil {
+			return reflect.Value{}, err
+		}
+		return kv.Elem(), nil
+	}
+	kv := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		kv.SetString(key)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(key, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("invalid map key %q for type %v", key, t)
+		}
+		kv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(key, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("invalid map key %q for type %v", key, t)
+		}
+		kv.SetUint(n)
+	default:
+		return reflect.Value{}, fmt.Errorf("unsupported map key type %v", t)
+	}
+	return kv, nil
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but allocates nil
+// embedded struct pointers along the way. Like encoding/json, it fails on
+// nil pointers to unexported structs, which cannot be set.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct: %v", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	urlType             = reflect.TypeOf(url.URL{})
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+func implementsUnmarshaler(t reflect.Type) bool {
+	pt := reflect.PtrTo(t)
+	return pt.Implements(jsonUnmarshalerType) || pt.Implements(textUnmarshalerType)
+}
+
+// raw is a JSON value, along with its offset in the translated JSON.
+type raw struct {
+	data []byte
+	off  int64
+}
+
+func (val raw) isNull() bool {
+	return bytes.Equal(val.data, []byte("null"))
+}
+
+type member struct {
+	key    string
+	keyOff int64
+	val    raw
+}
+
+// members splits the JSON object val into its members.
+func (val raw) members() ([]member, error) {
+	dec := json.NewDecoder(bytes.NewReader(val.data))
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, fmt.Errorf("json5: cannot unmarshal %s into an object", val.data)
+	}
+	var members []member
+	for dec.More() {
+		m := member{keyOff: val.off + skipSeparators(val.data, dec.InputOffset())}
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		m.key = tok.(string)
+		m.val.off = val.off + skipSeparators(val.data, dec.InputOffset())
+		if err := dec.Decode((*json.RawMessage)(&m.val.data)); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// elements splits the JSON array val into its elements.
+func (val raw) elements() ([]raw, error) {
+	dec := json.NewDecoder(bytes.NewReader(val.data))
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('[') {
+		return nil, fmt.Errorf("json5: cannot unmarshal %s into an array", val.data)
+	}
+	var elems []raw
+	for dec.More() {
+		elem := raw{off: val.off + skipSeparators(val.data, dec.InputOffset())}
+		if err := dec.Decode((*json.RawMessage)(&elem.data)); err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+// skipSeparators returns the offset of the first byte of data, starting at
+// offset, that is neither whitespace nor a separator.
+func skipSeparators(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n,:"), data[offset]) != -1 {
+		offset++
+	}
+	return offset
+}
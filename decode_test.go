@@ -0,0 +1,808 @@
+package json5
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecoderRegisterDecoder(t *testing.T) {
+	type backoff struct {
+		Steps []time.Duration `json:"steps"`
+	}
+	type config struct {
+		Name     string
+		Timeout  time.Duration `json:"timeout"`
+		Retry    *time.Duration
+		Backoff  backoff
+		Deadline map[string]time.Duration
+	}
+
+	in := `
+	{
+		// time.Duration is a third-party type: we cannot give it methods.
+		Name: 'server',
+		timeout: '1m30s',
+		retry: "5s",
+		backoff: { steps: ['1s', '2s', '4s',] },
+		deadline: { read: '10s' },
+	}
+	`
+
+	dec := NewDecoder(strings.NewReader(in))
+	dec.RegisterDecoder(reflect.TypeOf(time.Duration(0)), func(data []byte, v reflect.Value) error {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	})
+
+	var actual config
+	if err := dec.Decode(&actual); err != nil {
+		t.Fatal(err)
+	}
+
+	retry := 5 * time.Second
+	expected := config{
+		Name:     "server",
+		Timeout:  90 * time.Second,
+		Retry:    &retry,
+		Backoff:  backoff{Steps: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}},
+		Deadline: map[string]time.Duration{"read": 10 * time.Second},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %+v, got %+v", expected, actual)
+	}
+
+	// Registries are per-decoder: a fresh decoder knows nothing about the
+	// function above and falls back to encoding/json.
+	if err := Unmarshal([]byte(in), &actual); err == nil {
+		t.Fatal("expected an error decoding durations without a registered decoder")
+	}
+}
+
+func TestDecoderRegisterDecoderRecursive(t *testing.T) {
+	type node struct {
+		Next *node
+		D    time.Duration
+	}
+
+	dec := NewDecoder(strings.NewReader(`{ d: '1s', next: { d: '2s', next: { d: '3s' } } }`))
+	dec.RegisterDecoder(reflect.TypeOf(time.Duration(0)), func(data []byte, v reflect.Value) error {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(s)
+		v.SetInt(int64(d))
+		return err
+	})
+
+	var actual node
+	if err := dec.Decode(&actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := node{D: time.Second, Next: &node{D: 2 * time.Second, Next: &node{D: 3 * time.Second}}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func TestDecoderStringOption(t *testing.T) {
+	type config struct {
+		Port    int     `json:"port,string"`
+		Debug   bool    `json:"debug,string"`
+		Ratio   float64 `json:"ratio,string"`
+		Name    string  `json:"name,string"`
+		Retries *uint   `json:"retries,string"`
+		Timeout time.Duration
+	}
+	in := `{ port: '80', debug: "true", ratio: '0.5', name: '"api"', retries: '3', timeout: '1s' }`
+
+	decoders := map[string]*Decoder{
+		"registered decoder": NewDecoder(strings.NewReader(in)),
+		"key alias":          NewDecoder(strings.NewReader(strings.Replace(in, "timeout: '1s'", "timeout: 1000000000", 1)), WithKeyAlias(map[string]string{"old": "name"})),
+	}
+	decoders["registered decoder"].RegisterDecoder(reflect.TypeOf(time.Duration(0)), func(data []byte, v reflect.Value) error {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(s)
+		v.SetInt(int64(d))
+		return err
+	})
+
+	for name, dec := range decoders {
+		var actual config
+		if err := dec.Decode(&actual); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		retries := uint(3)
+		expected := config{Port: 80, Debug: true, Ratio: 0.5, Name: "api", Retries: &retries, Timeout: time.Second}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("%s: expected %+v, got %+v", name, expected, actual)
+		}
+	}
+
+	var actual config
+	err := NewDecoder(strings.NewReader(`{ port: 'eighty' }`), WithKeyAlias(map[string]string{"old": "name"})).Decode(&actual)
+	expected := `json5: at line 1 column 9: invalid use of ,string struct tag, trying to unmarshal "eighty" into int`
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestDecoderTypeErrors(t *testing.T) {
+	type server struct {
+		Host string `json5:"host"`
+		Port int    `json5:"port"`
+	}
+	type config struct {
+		Name   string `json5:"name"`
+		Server server `json5:"server"`
+		Debug  bool   `json5:"debug"`
+	}
+	in := `{
+  name: 42,
+  server: { host: 'localhost', port: 'eighty' },
+  debug: true,
+}`
+
+	var actual config
+	err := Unmarshal([]byte(in), &actual)
+	var derr *DecodeError
+	var ute *json.UnmarshalTypeError
+	if !errors.As(err, &derr) || !errors.As(err, &ute) {
+		t.Fatalf("expected a positioned type error, got %v", err)
+	}
+	expected := "json5: at line 2 column 9: json: cannot unmarshal number into Go struct field config.name of type string"
+	if err.Error() != expected {
+		t.Fatalf("expected error %q, got %q", expected, err)
+	}
+	// The other members are still decoded, as with encoding/json.
+	if expected := (config{Server: server{Host: "localhost"}, Debug: true}); actual != expected {
+		t.Fatalf("expected %+v, got %+v", expected, actual)
+	}
+
+	var nested config
+	err = Unmarshal([]byte(`{ server: { host: 'localhost', port: 'eighty' }, debug: true }`), &nested)
+	expected = "json5: at line 1 column 38: json: cannot unmarshal string into Go struct field config.server.port of type int"
+	if err == nil || err.Error() != expected || !nested.Debug {
+		t.Fatalf("expected error %q and debug to be set, got %v and %+v", expected, err, nested)
+	}
+}
+
+func TestDecoderDottedTags(t *testing.T) {
+	type database struct {
+		Host string `json5:"db.host"`
+		Port int    `json5:"db.port"`
+	}
+	type config struct {
+		Name string `json5:"name"`
+		DB   database
+	}
+
+	in := `
+	{
+		name: 'server',
+		"db.host": 'localhost',
+		"db.port": 5432,
+	}
+	`
+
+	var actual config
+	if err := Unmarshal([]byte(in), &actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := config{
+		Name: "server",
+		DB:   database{Host: "localhost", Port: 5432},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func TestDecoderEmbeddedUnexportedPointer(t *testing.T) {
+	type inner struct {
+		X string `json:"x"`
+	}
+	type outer struct {
+		*inner
+		Y string `json5:"y"`
+	}
+
+	var ok outer
+	if err := Unmarshal([]byte(`{ y: 'b' }`), &ok); err != nil {
+		t.Fatal(err)
+	}
+	if ok.Y != "b" || ok.inner != nil {
+		t.Fatalf("unexpected result %+v", ok)
+	}
+
+	var actual outer
+	err := Unmarshal([]byte(`{ x: 'a', y: 'b' }`), &actual)
+	expected := "json5: at line 1 column 3: cannot set embedded pointer to unexported struct: " + reflect.TypeOf(inner{}).String()
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestDecoderTagPrecedence(t *testing.T) {
+	in := `{ name: 'a', port: 1, secret: 'b', "-": 'c', Plain: 'd', server_name: 'e', other_name: ' f ', Hidden: 'g', count: '7' }`
+
+	type jsonOnly struct {
+		Name   string `json:"server_name"`
+		Port   int    `json:"port,omitempty"`
+		Secret string `json:"-"`
+		Dash   string `json:"-,"`
+		Plain  string
+		Count  int `json:"count,string"`
+	}
+	var j jsonOnly
+	if err := Unmarshal([]byte(in), &j); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (jsonOnly{Name: "e", Port: 1, Dash: "c", Plain: "d", Count: 7}); j != expected {
+		t.Fatalf("json tags only: expected %+v, got %+v", expected, j)
+	}
+	// The same, decoded by the Decoder itself rather than encoding/json.
+	var custom jsonOnly
+	if err := NewDecoder(strings.NewReader(in), WithKeyAlias(map[string]string{"old": "name"})).Decode(&custom); err != nil {
+		t.Fatal(err)
+	}
+	if custom != j {
+		t.Fatalf("json tags only, custom path: expected %+v, got %+v", j, custom)
+	}
+
+	type json5Only struct {
+		Name   string `json5:"server_name"`
+		Port   int    `json5:"port,omitempty"`
+		Secret string `json5:"-"`
+		Dash   string `json5:"-,"`
+		Plain  string
+	}
+	var j5 json5Only
+	if err := Unmarshal([]byte(in), &j5); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (json5Only{Name: "e", Port: 1, Dash: "c", Plain: "d"}); j5 != expected {
+		t.Fatalf("json5 tags only: expected %+v, got %+v", expected, j5)
+	}
+
+	type both struct {
+		Name   string `json:"server_name" json5:"name"`
+		Port   int    `json:"server_port" json5:"port"`
+		Secret string `json:"secret" json5:"-"`
+		Dash   string `json:"-" json5:"-,"`
+		Plain  string `json:"plain"`
+		Other  string `json:"other_name" json5:",trim"`
+		Hidden string `json:"-" json5:",trim"`
+	}
+	var b both
+	if err := Unmarshal([]byte(in), &b); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (both{Name: "a", Port: 1, Dash: "c", Plain: "d", Other: "f"}); b != expected {
+		t.Fatalf("json and json5 tags: expected %+v, got %+v", expected, b)
+	}
+}
+
+func TestNewSafeDecoder(t *testing.T) {
+	var normal struct {
+		Name  string
+		Ports []int
+	}
+	in := `{ name: 'server', ports: [80, 443,], }`
+	if err := NewSafeDecoder(strings.NewReader(in)).Decode(&normal); err != nil {
+		t.Fatal(err)
+	}
+	if normal.Name != "server" || !reflect.DeepEqual(normal.Ports, []int{80, 443}) {
+		t.Fatalf("unexpected result %+v", normal)
+	}
+
+	var keys strings.Builder
+	keys.WriteString("{")
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&keys, "k%d: %d,", i, i)
+	}
+	keys.WriteString("}")
+
+	abusive := map[string]string{
+		"deep":      strings.Repeat("[", 10000) + strings.Repeat("]", 10000),
+		"huge":      "'" + strings.Repeat("a", 2<<20) + "'",
+		"keys":      keys.String(),
+		"duplicate": "{ a: 1, a: 2 }",
+	}
+	for name, in := range abusive {
+		t.Run(name, func(t *testing.T) {
+			var v interface{}
+			err := NewSafeDecoder(strings.NewReader(in)).Decode(&v)
+			var lexErr *LexingError
+			if !errors.As(err, &lexErr) {
+				t.Fatalf("expected a lexing error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecoderPattern(t *testing.T) {
+	type host struct {
+		Name string  `json5:"name,pattern=^[a-z][a-z0-9-]*$"`
+		Zone *string `json5:"zone,pattern=^[a-z]{2,3}-[0-9]$"`
+	}
+
+	var valid host
+	if err := Unmarshal([]byte(`{ name: 'web-01', zone: 'eu-1' }`), &valid); err != nil {
+		t.Fatal(err)
+	}
+	if valid.Name != "web-01" || valid.Zone == nil || *valid.Zone != "eu-1" {
+		t.Fatalf("unexpected result %+v", valid)
+	}
+
+	var invalid host
+	err := Unmarshal([]byte(`{
+		name: 'web-01',
+		zone: 'EU',
+	}`), &invalid)
+	var decErr *DecodeError
+	if !errors.As(err, &decErr) {
+		t.Fatalf("expected a decode error, got %v", err)
+	}
+	expected := `json5: at line 3 column 9: value "EU" of field zone does not match pattern "^[a-z]{2,3}-[0-9]$"`
+	if err.Error() != expected {
+		t.Fatalf("expected error %q, got %q", expected, err.Error())
+	}
+
+	var broken struct {
+		Name string `json5:"name,pattern=^[a-z"`
+	}
+	err = Unmarshal([]byte(`{ name: 'web' }`), &broken)
+	if err == nil || !strings.Contains(err.Error(), "invalid pattern on field name") {
+		t.Fatalf("expected an invalid pattern error, got %v", err)
+	}
+}
+
+func TestDecoderURL(t *testing.T) {
+	type endpoints struct {
+		Base     *url.URL
+		Relative url.URL
+		Mirrors  []*url.URL
+		Missing  *url.URL
+	}
+
+	var actual endpoints
+	in := `{
+		base: 'https://example.com/api/',
+		relative: '../v2/items?limit=10',
+		mirrors: ['https://a.example.com', 'https://b.example.com'],
+		missing: null,
+	}`
+	if err := Unmarshal([]byte(in), &actual); err != nil {
+		t.Fatal(err)
+	}
+	if actual.Base.String() != "https://example.com/api/" {
+		t.Fatalf("unexpected base URL %v", actual.Base)
+	}
+	if actual.Relative.IsAbs() || actual.Relative.Path != "../v2/items" || actual.Relative.RawQuery != "limit=10" {
+		t.Fatalf("unexpected relative URL %v", &actual.Relative)
+	}
+	if resolved := actual.Base.ResolveReference(&actual.Relative).String(); resolved != "https://example.com/v2/items?limit=10" {
+		t.Fatalf("unexpected resolved URL %v", resolved)
+	}
+	if len(actual.Mirrors) != 2 || actual.Mirrors[1].Host != "b.example.com" {
+		t.Fatalf("unexpected mirrors %v", actual.Mirrors)
+	}
+	if actual.Missing != nil {
+		t.Fatalf("expected no URL, got %v", actual.Missing)
+	}
+
+	err := Unmarshal([]byte(`{
+		base: 'https://example.com/',
+		relative: 'http://[::1',
+	}`), &actual)
+	var decErr *DecodeError
+	if !errors.As(err, &decErr) {
+		t.Fatalf("expected a decode error, got %v", err)
+	}
+	if decErr.Line != 3 || decErr.Column != 13 {
+		t.Fatalf("expected error at line 3 column 13, got %v", err)
+	}
+}
+
+func TestDecoderVersionGate(t *testing.T) {
+	type config struct {
+		Version int    `json5:"version"`
+		Name    string `json5:"name"`
+		NewFlag bool   `json5:"newflag,since=2"`
+	}
+
+	var v2 config
+	in := `{ version: 2, name: 'server', newflag: true }`
+	if err := NewDecoder(strings.NewReader(in), WithVersionKey("version")).Decode(&v2); err != nil {
+		t.Fatal(err)
+	}
+	if !v2.NewFlag {
+		t.Fatalf("expected newflag to be set, got %+v", v2)
+	}
+
+	var v1 config
+	in = `{
+		version: 1,
+		name: 'server',
+		newflag: true,
+	}`
+	err := NewDecoder(strings.NewReader(in), WithVersionKey("version")).Decode(&v1)
+	expected := "json5: at line 4 column 3: field newflag requires version 2, but the document declares version 1"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+
+	// Fields that are not present are fine in older documents.
+	if err := NewDecoder(strings.NewReader(`{ version: 1, name: 'server' }`), WithVersionKey("version")).Decode(&v1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecoderKeyAlias(t *testing.T) {
+	type config struct {
+		Hostname string `json5:"hostname"`
+		Port     int    `json5:"port"`
+	}
+
+	in := `{
+		// "host" was renamed to "hostname"
+		host: 'example.com',
+		port: 8080,
+	}`
+	dec := NewDecoder(strings.NewReader(in), WithKeyAlias(map[string]string{"host": "hostname"}))
+	var actual config
+	if err := dec.Decode(&actual); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (config{Hostname: "example.com", Port: 8080}); actual != expected {
+		t.Fatalf("expected %+v, got %+v", expected, actual)
+	}
+
+	expected := []Warning{{Line: 3, Column: 3, Message: `key "host" is deprecated, use "hostname" instead`}}
+	if !reflect.DeepEqual(expected, dec.Warnings()) {
+		t.Fatalf("expected warnings %v, got %v", expected, dec.Warnings())
+	}
+}
+
+func TestDecoderNull(t *testing.T) {
+	type plain struct {
+		A *int
+		B []*string
+		C *[]int
+		D *int
+	}
+	type tagged struct {
+		A *int      `json5:"a"`
+		B []*string `json5:"b"`
+		C *[]int    `json5:"c"`
+		D *int      `json5:"d"`
+	}
+
+	in := `{a: null, b: [null,], c: null, d: 1,}`
+	one := 1
+
+	var p plain
+	if err := Unmarshal([]byte(in), &p); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (plain{B: []*string{nil}, D: &one}); !reflect.DeepEqual(expected, p) {
+		t.Fatalf("expected %+v, got %+v", expected, p)
+	}
+
+	var tg tagged
+	if err := Unmarshal([]byte(in), &tg); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (tagged{B: []*string{nil}, D: &one}); !reflect.DeepEqual(expected, tg) {
+		t.Fatalf("expected %+v, got %+v", expected, tg)
+	}
+}
+
+func TestDecoderMaxStringBytes(t *testing.T) {
+	in := `{
+		first: 'abcdefghij',
+		second: 'abcdefghij',
+		third: 'abcdefghij',
+	}`
+
+	var v map[string]string
+	dec := NewDecoder(strings.NewReader(in))
+	dec.SetMaxStringBytes(64)
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	// No single string is large, but together they exceed the budget.
+	dec = NewDecoder(strings.NewReader(in))
+	dec.SetMaxStringBytes(40)
+	err := dec.Decode(&v)
+	expected := "json5: at line 4 column 15: strings exceed maximum total size of 40 bytes"
+	var lexErr *LexingError
+	if !errors.As(err, &lexErr) || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestDecoderEpoch(t *testing.T) {
+	type event struct {
+		Seconds time.Time  `json5:"s,epoch=s"`
+		Millis  time.Time  `json5:"ms,epoch=ms"`
+		Nanos   *time.Time `json5:"ns,epoch=ns"`
+		Missing *time.Time `json5:"missing,epoch=ms"`
+	}
+
+	var actual event
+	in := `{ s: 1700000000, ms: 1700000000123, ns: 1700000000123456789, missing: null }`
+	if err := Unmarshal([]byte(in), &actual); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := time.Date(2023, time.November, 14, 22, 13, 20, 0, time.UTC)
+	if !actual.Seconds.Equal(expected) {
+		t.Fatalf("expected %v, got %v", expected, actual.Seconds)
+	}
+	if expected := expected.Add(123 * time.Millisecond); !actual.Millis.Equal(expected) {
+		t.Fatalf("expected %v, got %v", expected, actual.Millis)
+	}
+	if expected := expected.Add(123456789); actual.Nanos == nil || !actual.Nanos.Equal(expected) {
+		t.Fatalf("expected %v, got %v", expected, actual.Nanos)
+	}
+	if actual.Missing != nil {
+		t.Fatalf("expected no time, got %v", actual.Missing)
+	}
+
+	err := Unmarshal([]byte(`{ ms: 'yesterday' }`), &actual)
+	expectedErr := `json5: at line 1 column 7: epoch timestamp "yesterday" of field ms is not an integer`
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("expected error %q, got %v", expectedErr, err)
+	}
+}
+
+func TestDecoderWhitespace(t *testing.T) {
+	type labels struct {
+		Raw       string  `json5:"raw"`
+		Trimmed   string  `json5:"trimmed,trim"`
+		Collapsed *string `json5:"collapsed,collapse"`
+	}
+
+	const label = " \t Hello,   wide \t world!  "
+	in := fmt.Sprintf(`{ raw: %q, trimmed: %[1]q, collapsed: %[1]q }`, label)
+
+	var actual labels
+	if err := Unmarshal([]byte(in), &actual); err != nil {
+		t.Fatal(err)
+	}
+	if actual.Raw != label {
+		t.Fatalf("expected %q, got %q", label, actual.Raw)
+	}
+	if expected := "Hello,   wide \t world!"; actual.Trimmed != expected {
+		t.Fatalf("expected %q, got %q", expected, actual.Trimmed)
+	}
+	if expected := "Hello, wide world!"; actual.Collapsed == nil || *actual.Collapsed != expected {
+		t.Fatalf("expected %q, got %v", expected, actual.Collapsed)
+	}
+}
+
+func TestDecoderIntegers(t *testing.T) {
+	in := `{
+		zero: 0,
+		hex: 0xff,
+		negative: -42,
+		maxInt64: 9223372036854775807,
+		maxInt64Plus1: 9223372036854775808,
+		maxUint64: 18446744073709551615,
+		maxUint64Plus1: 18446744073709551616,
+		minInt64: -9223372036854775808,
+		minInt64Minus1: -9223372036854775809,
+		fraction: 1.5,
+		exponent: 1e3,
+		nested: [1, { two: 2 }],
+	}`
+
+	var actual interface{}
+	if err := NewDecoder(strings.NewReader(in), WithIntegers()).Decode(&actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"zero":           int64(0),
+		"hex":            int64(255),
+		"negative":       int64(-42),
+		"maxInt64":       int64(math.MaxInt64),
+		"maxInt64Plus1":  uint64(math.MaxInt64 + 1),
+		"maxUint64":      uint64(math.MaxUint64),
+		"maxUint64Plus1": float64(math.MaxUint64),
+		"minInt64":       int64(math.MinInt64),
+		"minInt64Minus1": float64(math.MinInt64),
+		"fraction":       1.5,
+		"exponent":       1000.0,
+		"nested":         []interface{}{int64(1), map[string]interface{}{"two": int64(2)}},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %#v, got %#v", expected, actual)
+	}
+
+	// Interfaces nested in typed values are decoded the same way.
+	var typed struct {
+		Any  interface{}
+		List []interface{}
+	}
+	if err := NewDecoder(strings.NewReader(`{ any: 1, list: [2, 3.5] }`), WithIntegers()).Decode(&typed); err != nil {
+		t.Fatal(err)
+	}
+	if typed.Any != int64(1) || !reflect.DeepEqual(typed.List, []interface{}{int64(2), 3.5}) {
+		t.Fatalf("unexpected result %#v", typed)
+	}
+}
+
+func TestDecoderSpans(t *testing.T) {
+	in := "{\n" +
+		"  name: 'api',\n" +
+		"  // the listeners\n" +
+		"  servers: [\n" +
+		"    { host: \"a\", port: 0x50 },\n" +
+		"  ],\n" +
+		"}"
+
+	dec := NewDecoder(strings.NewReader(in), WithSpans())
+	var actual map[string]interface{}
+	if err := dec.Decode(&actual); err != nil {
+		t.Fatal(err)
+	}
+
+	span := func(line, col, endLine, endCol int) Span {
+		offset := func(line, col int) int64 {
+			lines := strings.SplitAfter(in, "\n")
+			n := len(strings.Join(lines[:line-1], ""))
+			return int64(n + col - 1)
+		}
+		return Span{
+			Start: Position{Offset: offset(line, col), Line: line, Column: col},
+			End:   Position{Offset: offset(endLine, endCol), Line: endLine, Column: endCol},
+		}
+	}
+	expected := map[string]Span{
+		"name":            span(2, 3, 2, 14),
+		"servers":         span(4, 3, 6, 4),
+		"servers[0].host": span(5, 7, 5, 16),
+		"servers[0].port": span(5, 18, 5, 28),
+	}
+	if spans := dec.Spans(); !reflect.DeepEqual(expected, spans) {
+		t.Fatalf("expected %+v, got %+v", expected, spans)
+	}
+}
+
+func TestDecoderEntryValidator(t *testing.T) {
+	type Server struct {
+		Host string
+		Port int
+	}
+	in := `{
+  web: { host: 'example.com', port: 443 },
+  db: { host: 'localhost', port: 0 },
+  cache: { host: 'localhost', port: 6379 },
+}`
+
+	var keys []string
+	validate := func(key string, v reflect.Value, pos Position) error {
+		keys = append(keys, key)
+		if v.Interface().(Server).Port == 0 {
+			return fmt.Errorf("server %q at line %v has no port", key, pos.Line)
+		}
+		return nil
+	}
+
+	var actual map[string]Server
+	err := NewDecoder(strings.NewReader(in), WithEntryValidator(validate)).Decode(&actual)
+	var derr *DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("expected a DecodeError, got %v", err)
+	}
+	if derr.Line != 3 || derr.Column != 3 {
+		t.Fatalf("expected error at line 3 column 3, got line %v column %v", derr.Line, derr.Column)
+	}
+	if expected := `server "db" at line 3 has no port`; derr.Err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, derr.Err)
+	}
+	if !reflect.DeepEqual(keys, []string{"web", "db"}) {
+		t.Fatalf("expected entries web and db to be validated, got %v", keys)
+	}
+}
+
+func TestDecoderCommentsAroundColon(t *testing.T) {
+	in := `{
+		name /* the service */ : 'api',
+		port: /* default */ 8080,
+		tags // kept short
+			: ['a'],
+	}`
+
+	var actual struct {
+		Name string
+		Port int
+		Tags []string
+	}
+	if err := Unmarshal([]byte(in), &actual); err != nil {
+		t.Fatal(err)
+	}
+	if actual.Name != "api" || actual.Port != 8080 || !reflect.DeepEqual(actual.Tags, []string{"a"}) {
+		t.Fatalf("unexpected result %+v", actual)
+	}
+}
+
+func TestDecoderTrackPositions(t *testing.T) {
+	in := "[" + strings.Repeat("true, null, 1, ", 20000) + "false]"
+
+	// Plain values are handed to encoding/json, which does not need their
+	// positions.
+	dec := NewDecoder(strings.NewReader(in))
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if len(dec.rd.marks) > 1 {
+		t.Fatalf("expected positions to be dropped, got %d", len(dec.rd.marks))
+	}
+
+	// They are still tracked for the values that follow.
+	type named struct {
+		Name string `json5:"name,pattern=^[a-z]+$"`
+	}
+	dec = NewDecoder(strings.NewReader("[1]\n{name: 'B'}"))
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	var n named
+	err := dec.Decode(&n)
+	if err == nil || err.Error() != `json5: at line 2 column 8: value "B" of field name does not match pattern "^[a-z]+$"` {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Positions no longer needed are dropped while reading tokens.
+	dec = NewDecoder(strings.NewReader(in), WithSpans())
+	for {
+		if _, err := dec.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if len(dec.rd.marks) > 10000 {
+			t.Fatalf("expected positions to be dropped, got %d", len(dec.rd.marks))
+		}
+	}
+
+	// Keywords and numbers are still positioned at their start.
+	var items []named
+	err = Unmarshal([]byte("[{ name: 'a', x: [true, -12, null] }, { name: 'B' }]"), &items)
+	if err == nil || err.Error() != `json5: at line 1 column 47: value "B" of field name does not match pattern "^[a-z]+$"` {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var spans map[string]interface{}
+	dec = NewDecoder(strings.NewReader("{ a: [true, -12], b: null }"), WithSpans())
+	if err := dec.Decode(&spans); err != nil {
+		t.Fatal(err)
+	}
+	expected := Span{Start: Position{Offset: 2, Line: 1, Column: 3}, End: Position{Offset: 16, Line: 1, Column: 17}}
+	if span := dec.Spans()["a"]; span != expected {
+		t.Fatalf("expected span %+v, got %+v", expected, span)
+	}
+}
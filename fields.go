@@ -0,0 +1,256 @@
+package json5
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// field describes a struct field that can be decoded into, following the
+// same naming and embedding rules as encoding/json.
+//
+// Field names come from the json5 struct tag when present, and from the json
+// tag otherwise. A json5 tag without a name, such as ",trim", only adds
+// options to the json tag, whose name is kept. A json5 tag name containing
+// dots, such as "db.host", is a flat key: the field is decoded from the
+// member with exactly that key in the object of any struct that contains the
+// field, however deeply nested.
+type field struct {
+	name   string
+	tagged bool
+	json5  bool
+	opts   tagOptions
+	quoted bool
+	index  []int
+	typ    reflect.Type
+}
+
+// tagOptions are the comma-separated options following the name in a
+// json5 struct tag. An option of the form pattern=... runs to the end of the
+// tag, so that the regular expression may contain commas.
+type tagOptions string
+
+// Get returns the value of the key=value option, or whether the bare key
+// option is present when it has no value.
+func (o tagOptions) Get(key string) (string, bool) {
+	s := string(o)
+	for s != "" {
+		opt := s
+		if strings.HasPrefix(s, "pattern=") {
+			s = ""
+		} else if comma := strings.Index(s, ","); comma != -1 {
+			opt, s = s[:comma], s[comma+1:]
+		} else {
+			s = ""
+		}
+		if opt == key {
+			return "", true
+		}
+		if strings.HasPrefix(opt, key+"=") {
+			return opt[len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+type structFields []field
+
+// lookup returns the field matching key, preferring an exact match over a
+// case-insensitive one, or nil if there is none.
+func (fs structFields) lookup(key string) *field {
+	var fold *field
+	for i := range fs {
+		if fs[i].name == key {
+			return &fs[i]
+		}
+		if fold == nil && strings.EqualFold(fs[i].name, key) {
+			fold = &fs[i]
+		}
+	}
+	return fold
+}
+
+var fieldCache sync.Map // map[reflect.Type]structFields
+
+func cachedFields(t reflect.Type) structFields {
+	if fs, ok := fieldCache.Load(t); ok {
+		return fs.(structFields)
+	}
+	fields := typeFields(t)
+	fields = append(fields, dottedFields(fields, nil, map[reflect.Type]bool{t: true})...)
+	fs, _ := fieldCache.LoadOrStore(t, fields.dedup())
+	return fs.(structFields)
+}
+
+// dottedFields returns the fields with dotted names found in the structs
+// nested in fields, with their index rebased on prefix.
+func dottedFields(fields structFields, prefix []int, seen map[reflect.Type]bool) structFields {
+	var dotted structFields
+	for _, f := range fields {
+		index := append(append([]int(nil), prefix...), f.index...)
+		if len(prefix) > 0 && strings.Contains(f.name, ".") {
+			f.index = index
+			dotted = append(dotted, f)
+			continue
+		}
+		ft := f.typ
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() != reflect.Struct || seen[ft] {
+			continue
+		}
+		seen[ft] = true
+		dotted = append(dotted, dottedFields(typeFields(ft), index, seen)...)
+		delete(seen, ft)
+	}
+	return dotted
+}
+
+// dedup drops the fields whose name is already used by a previous field.
+func (fs structFields) dedup() structFields {
+	names := make(map[string]bool, len(fs))
+	out := fs[:0]
+	for _, f := range fs {
+		if names[f.name] {
+			continue
+		}
+		names[f.name] = true
+		out = append(out, f)
+	}
+	return out
+}
+
+// fieldTag returns the tag used to name sf, and whether it is a json5 tag.
+func fieldTag(sf reflect.StructField) (string, bool) {
+	if tag, ok := sf.Tag.Lookup("json5"); ok {
+		return tag, true
+	}
+	return sf.Tag.Get("json"), false
+}
+
+func typeFields(t reflect.Type) structFields {
+	type candidate struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var fields structFields
+	visited := map[reflect.Type]bool{}
+	next := []candidate{{typ: t}}
+	for len(next) > 0 {
+		current := next
+		next = nil
+		for _, c := range current {
+			if visited[c.typ] {
+				continue
+			}
+			visited[c.typ] = true
+
+			for i := 0; i < c.typ.NumField(); i++ {
+				sf := c.typ.Field(i)
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous {
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+
+				tag, json5 := fieldTag(sf)
+				if tag == "-" {
+					continue
+				}
+				name, opts := tag, ""
+				if comma := strings.Index(tag, ","); comma != -1 {
+					name, opts = tag[:comma], tag[comma+1:]
+				}
+				var jsonOpts string
+				if !json5 {
+					jsonOpts, opts = opts, ""
+				} else if name == "" {
+					name = sf.Tag.Get("json")
+					if name == "-" {
+						continue
+					}
+					if comma := strings.Index(name, ","); comma != -1 {
+						name, jsonOpts = name[:comma], name[comma+1:]
+					}
+				}
+				// Of the options of json tags, only string matters when
+				// decoding: the value is then quoted in a JSON string.
+				quoted := false
+				if _, ok := tagOptions(jsonOpts).Get("string"); ok {
+					switch ft.Kind() {
+					case reflect.Bool,
+						reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+						reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+						reflect.Float32, reflect.Float64,
+						reflect.String:
+						quoted = true
+					}
+				}
+				index := make([]int, len(c.index)+1)
+				copy(index, c.index)
+				index[len(c.index)] = i
+
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, candidate{typ: ft, index: index})
+					continue
+				}
+				f := field{name: name, tagged: name != "", json5: json5, opts: tagOptions(opts), quoted: quoted, index: index, typ: sf.Type}
+				if f.name == "" {
+					f.name = sf.Name
+				}
+				fields = append(fields, f)
+			}
+		}
+	}
+
+	// Resolve name conflicts: shallower fields win, then tagged ones;
+	// ambiguous names are dropped entirely.
+	sort.SliceStable(fields, func(i, j int) bool {
+		if fields[i].name != fields[j].name {
+			return fields[i].name < fields[j].name
+		}
+		if len(fields[i].index) != len(fields[j].index) {
+			return len(fields[i].index) < len(fields[j].index)
+		}
+		return fields[i].tagged && !fields[j].tagged
+	})
+	out := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		dominant := fields[i]
+		if j-i > 1 && len(fields[i+1].index) == len(dominant.index) && fields[i+1].tagged == dominant.tagged {
+			i = j
+			continue
+		}
+		out = append(out, dominant)
+		i = j
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return lessIndex(out[i].index, out[j].index)
+	})
+	return out
+}
+
+func lessIndex(a, b []int) bool {
+	for i := range a {
+		if i >= len(b) {
+			return false
+		}
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
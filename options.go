@@ -0,0 +1,147 @@
+package json5
+
+import "reflect"
+
+// An Option configures a Reader, or the Reader underlying a Decoder.
+type Option func(*options)
+
+type options struct {
+	maxDepth            int
+	maxSize             int64
+	maxKeys             int
+	maxTokenLength      int
+	maxStringBytes      int64
+	rejectDuplicateKeys bool
+	autoClose           bool
+	versionKey          string
+	keyAliases          map[string]string
+	shebang             bool
+	preserveIndent      bool
+	integers            bool
+	spans               bool
+	consistentQuotes    bool
+	entryValidator      func(key string, v reflect.Value, pos Position) error
+}
+
+// WithMaxDepth limits how deeply objects and arrays may be nested.
+func WithMaxDepth(n int) Option {
+	return func(o *options) {
+		o.maxDepth = n
+	}
+}
+
+// WithMaxSize limits the size in bytes of the JSON5 input.
+func WithMaxSize(n int64) Option {
+	return func(o *options) {
+		o.maxSize = n
+	}
+}
+
+// WithMaxKeys limits the number of keys in any single object.
+func WithMaxKeys(n int) Option {
+	return func(o *options) {
+		o.maxKeys = n
+	}
+}
+
+// WithMaxTokenLength limits the length in runes of strings, identifiers and
+// numbers, as written in the source and excluding quotes.
+func WithMaxTokenLength(n int) Option {
+	return func(o *options) {
+		o.maxTokenLength = n
+	}
+}
+
+// WithRejectDuplicateKeys causes objects that define the same key more than
+// once to be rejected, rather than having the last definition win.
+func WithRejectDuplicateKeys() Option {
+	return func(o *options) {
+		o.rejectDuplicateKeys = true
+	}
+}
+
+// WithAutoClose helps recovering truncated documents, such as files left
+// behind by a crashed editor. When the input ends while objects or arrays
+// are still open, they are closed instead of failing. A final member or
+// element that may be incomplete is dropped: a key without a value yet, an
+// unterminated string, or a number or keyword that the input ends right
+// after, as in [1, nul or [1, 2.
+func WithAutoClose() Option {
+	return func(o *options) {
+		o.autoClose = true
+	}
+}
+
+// WithVersionKey designates the top-level key holding the schema version
+// of documents read by a Decoder. Fields tagged with the since=N option are
+// then rejected when present in a document that declares a version older
+// than N. Documents without that key are not checked.
+func WithVersionKey(key string) Option {
+	return func(o *options) {
+		o.versionKey = key
+	}
+}
+
+// WithKeyAlias makes a Decoder accept deprecated keys of struct fields. Each
+// key of aliases is an old name, which is replaced by its new name before
+// being matched against the fields of the destination. Every replacement is
+// reported as a Warning.
+func WithKeyAlias(aliases map[string]string) Option {
+	return func(o *options) {
+		o.keyAliases = aliases
+	}
+}
+
+// WithShebang skips the first line of the input when it starts with #!, as
+// in executable configuration files. The #! must be the very first bytes.
+func WithShebang() Option {
+	return func(o *options) {
+		o.shebang = true
+	}
+}
+
+// WithPreserveIndent keeps the spaces and tabs starting a line that
+// continues a string after a backslash. By default they are treated as
+// indentation of the document and dropped from the string.
+func WithPreserveIndent() Option {
+	return func(o *options) {
+		o.preserveIndent = true
+	}
+}
+
+// WithIntegers makes a Decoder store integral numbers decoded into an
+// interface{} as int64, or as uint64 when they are positive and too large
+// for an int64. Other numbers, including those written with a fraction or an
+// exponent, remain float64.
+func WithIntegers() Option {
+	return func(o *options) {
+		o.integers = true
+	}
+}
+
+// WithSpans makes a Decoder record where each object member of the decoded
+// values is defined in the source, as reported by Decoder.Spans.
+func WithSpans() Option {
+	return func(o *options) {
+		o.spans = true
+	}
+}
+
+// WithConsistentQuotes rejects documents that quote strings and keys both
+// with single and double quotes. Whichever style comes first is the one the
+// rest of the document must use.
+func WithConsistentQuotes() Option {
+	return func(o *options) {
+		o.consistentQuotes = true
+	}
+}
+
+// WithEntryValidator makes a Decoder call fn after decoding each entry of a
+// map, with the key of the entry, its decoded value and the position of the
+// key in the source. An error returned by fn stops decoding, and is
+// reported as a DecodeError at that position.
+func WithEntryValidator(fn func(key string, v reflect.Value, pos Position) error) Option {
+	return func(o *options) {
+		o.entryValidator = fn
+	}
+}
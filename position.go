@@ -0,0 +1,110 @@
+package json5
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Position is a location in a JSON5 source. Offset is in bytes from the
+// start of the input, while Line and Column start at 1.
+type Position struct {
+	Offset int64
+	Line   int
+	Column int
+}
+
+// A Span is the range of a JSON5 source from Start up to, but excluding,
+// End.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// A DecodeError reports a value that could not be decoded into its Go
+// destination, at the position where the value starts in the JSON5 source.
+type DecodeError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("json5: at line %v column %v: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// A Warning reports something suspicious but not erroneous about a
+// document, at a position in its JSON5 source.
+type Warning struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("json5: at line %v column %v: %v", w.Line, w.Column, w.Message)
+}
+
+// mark maps a byte offset in the translated JSON to the position of the
+// JSON5 source that produced it.
+type mark struct {
+	out int64
+	pos Position
+}
+
+// TrackPositions makes the Reader remember where each piece of the
+// translated JSON comes from in the source, so that Position can be used.
+// It must be called before the first Read.
+func (r *Reader) TrackPositions() {
+	r.track = true
+}
+
+// Position returns the position in the JSON5 source of the token that was
+// translated into the JSON byte at offset. It requires TrackPositions.
+func (r *Reader) Position(offset int64) Position {
+	i := sort.Search(len(r.marks), func(i int) bool {
+		return r.marks[i].out > offset
+	})
+	if i == 0 {
+		return Position{Line: 1, Column: 1}
+	}
+	return r.marks[i-1].pos
+}
+
+// positionAfter returns the position in the JSON5 source that follows the
+// token translated into the JSON byte just before offset.
+func (r *Reader) positionAfter(offset int64) Position {
+	i := sort.Search(len(r.marks), func(i int) bool {
+		return r.marks[i].out >= offset
+	})
+	if i == len(r.marks) {
+		return Position{Offset: r.offset, Line: r.line, Column: r.col + 1}
+	}
+	return r.marks[i].pos
+}
+
+// mark records that the next JSON byte comes from the next source rune.
+func (r *Reader) mark() {
+	if !r.track {
+		return
+	}
+	out := r.queued
+	for _, tok := range r.held {
+		out += tok.size()
+	}
+	pos := Position{Offset: r.offset, Line: r.line, Column: r.col + 1}
+	r.marks = append(r.marks, mark{out: out, pos: pos})
+}
+
+// forget drops the marks that are only needed for JSON bytes before offset.
+func (r *Reader) forget(offset int64) {
+	i := sort.Search(len(r.marks), func(i int) bool {
+		return r.marks[i].out > offset
+	})
+	if i > 1 {
+		r.marks = append(r.marks[:0], r.marks[i-1:]...)
+	}
+}
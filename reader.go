@@ -3,8 +3,10 @@ package json5
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"strconv"
 	"strings"
@@ -31,36 +33,78 @@ func (e *LexingError) Unwrap() error {
 // Note that the result is not guaranteed to be valid JSON; the reader
 // should be fed to an actual json decoder for validation.
 type Reader struct {
-	rd      io.RuneScanner
-	state   stateFunc
-	line    int
-	col     int
-	lastcol int
-	quote   rune
-	comma   bool
-	noident bool
-	remain  []byte
-	tokens  chan token
-}
-
-func NewReader(rd io.Reader) *Reader {
+	rd       io.RuneScanner
+	opts     options
+	state    stateFunc
+	line     int
+	col      int
+	lastcol  int
+	lastnl   bool
+	offset   int64
+	lastsize int
+	quote    rune
+	docquote rune
+	strkey   bool
+	comma    bool
+	noident  bool
+	colon    bool
+	stack    []frame
+	key      []rune
+	keyline  int
+	keycol   int
+	toklen   int
+	strbytes int64
+	written  int64
+	remain   []byte
+	tokens   []token
+	queued   int64
+	holding  bool
+	scalar   bool
+	held     []token
+	track    bool
+	discard  bool
+	marks    []mark
+}
+
+// frame is an object or array being read.
+type frame struct {
+	kind rune
+	keys int
+	seen map[string]bool
+}
+
+func NewReader(rd io.Reader, opts ...Option) *Reader {
 	var scanner io.RuneScanner
 	if in, ok := rd.(io.RuneScanner); ok {
 		scanner = in
 	} else {
 		scanner = bufio.NewReader(rd)
 	}
-	return &Reader{
+	r := &Reader{
 		rd:      scanner,
 		state:   (*Reader).lex,
 		line:    1,
-		tokens:  make(chan token, 3),
 	}
+	for _, opt := range opts {
+		opt(&r.opts)
+	}
+	if r.opts.shebang {
+		r.state = (*Reader).lexShebang
+	}
+	return r
 }
 
-func (r *Reader) Read(buf []byte) (int, error) {
-	i := copy(buf, r.remain)
-	r.remain = nil
+func (r *Reader) Read(buf []byte) (i int, err error) {
+	defer func() {
+		r.written += int64(i)
+	}()
+	if r.discard {
+		// The JSON read so far is not needed anymore, see Decoder.Decode.
+		r.forget(r.written)
+	}
+
+	i = copy(buf, r.remain)
+	r.remain = r.remain[i:]
 
 	for i < len(buf) {
 		tok := r.next()
@@ -74,7 +118,7 @@ func (r *Reader) Read(buf []byte) (int, error) {
 			if copied < l {
 				r.remain = encoded[copied:l]
 			}
-			i += l
+			i += copied
 		case tokenNumber:
 			copied := copy(buf[i:], tok.num)
 			if copied < len(tok.num) {
@@ -86,31 +130,176 @@ func (r *Reader) Read(buf []byte) (int, error) {
 	return i, nil
 }
 
-func (r *Reader) next() token {
+// SetMaxStringBytes limits the total size in bytes of the contents of all
+// strings and unquoted keys in the input, which bounds the memory needed to
+// decode it even when no single string is large.
+func (r *Reader) SetMaxStringBytes(n int) {
+	r.opts.maxStringBytes = int64(n)
+}
+
+// countString adds the last rune read to the total size of strings.
+func (r *Reader) countString() error {
+	r.strbytes += int64(r.lastsize)
+	if max := r.opts.maxStringBytes; max > 0 && r.strbytes > max {
+		return fmt.Errorf("strings exceed maximum total size of %d bytes", max)
+	}
+	return nil
+}
+
+// countToken adds the last rune read to the length of the current string,
+// identifier or number.
+func (r *Reader) countToken() error {
+	r.toklen++
+	if max := r.opts.maxTokenLength; max > 0 && r.toklen > max {
+		return fmt.Errorf("token exceeds maximum length of %d", max)
+	}
+	return nil
+}
+
+// Stats returns the number of bytes consumed from the JSON5 source, and the
+// number of bytes of JSON produced so far.
+func (r *Reader) Stats() (sourceBytes, jsonBytes int64) {
+	return r.offset, r.written
+}
+
+// WriteTo implements io.WriterTo. It writes the translated JSON to w until
+// the input is exhausted or an error occurs.
+func (r *Reader) WriteTo(w io.Writer) (int64, error) {
+	var (
+		buf [4096]byte
+		n   int64
+	)
 	for {
-		select {
-		case tok := <-r.tokens:
-			return tok
-		default:
-			r.state = r.state(r)
+		l, err := r.Read(buf[:])
+		if l > 0 {
+			written, werr := w.Write(buf[:l])
+			n += int64(written)
+			if werr != nil {
+				return n, werr
+			}
+		}
+		if err == io.EOF {
+			return n, nil
 		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// ToJSON translates the JSON5 document in data to JSON.
+func ToJSON(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	_, err := NewReader(bytes.NewReader(data)).WriteTo(&out)
+	return out.Bytes(), err
+}
+
+// Checksum translates the JSON5 document in data and writes the resulting
+// JSON into h, without buffering the translation in memory.
+func Checksum(data []byte, h hash.Hash) error {
+	_, err := NewReader(bytes.NewReader(data)).WriteTo(h)
+	return err
+}
+
+func (r *Reader) next() token {
+	for len(r.tokens) == 0 {
+		r.state = r.state(r)
+	}
+	tok := r.tokens[0]
+	r.tokens = r.tokens[1:]
+	if len(r.tokens) == 0 {
+		r.tokens = r.tokens[:0]
 	}
+	return tok
 }
 
 func (r *Reader) emit(typ tokenType, val rune) {
-	r.tokens <- token{typ: typ, val: val}
+	if r.key != nil {
+		r.key = append(r.key, val)
+	}
+	if r.holding {
+		r.held = append(r.held, token{typ: typ, val: val})
+		return
+	}
+	r.queue(token{typ: typ, val: val})
+}
+
+func (r *Reader) queue(tok token) {
+	r.tokens = append(r.tokens, tok)
+	r.queued += tok.size()
+}
+
+// hold starts holding back emitted tokens, as they may belong to a member
+// that must be dropped if the input ends before it is complete. This only
+// applies when auto-closing containers.
+func (r *Reader) hold() {
+	if r.opts.autoClose && len(r.stack) > 0 {
+		r.holding = true
+	}
+}
+
+// release emits the tokens held back so far, and stops holding.
+func (r *Reader) release() {
+	r.scalar = false
+	if r.holding {
+		for _, tok := range r.held {
+			r.queue(tok)
+		}
+		r.held = r.held[:0]
+		r.holding = false
+	}
+}
+
+// holdScalar holds back a number or keyword value, which may still be cut
+// short by the end of the input, until a delimiter shows it is complete.
+func (r *Reader) holdScalar() {
+	r.hold()
+	r.scalar = true
+}
+
+// endScalar releases the number or keyword value being read, if any, now
+// that a delimiter follows it.
+func (r *Reader) endScalar() {
+	if r.scalar {
+		r.release()
+	}
+}
+
+// autoClose drops the tokens of any incomplete member, and closes all the
+// containers that are still open.
+func (r *Reader) autoClose() {
+	r.held = r.held[:0]
+	r.holding = false
+	for len(r.marks) > 0 && r.marks[len(r.marks)-1].out > r.queued {
+		r.marks = r.marks[:len(r.marks)-1]
+	}
+	for i := len(r.stack) - 1; i >= 0; i-- {
+		if r.stack[i].kind == '{' {
+			r.emit(tokenRune, '}')
+		} else {
+			r.emit(tokenRune, ']')
+		}
+	}
+	r.stack = nil
 }
 
 type stateFunc func(*Reader) stateFunc
 
 func (r *Reader) err(err error) stateFunc {
-	if err != io.EOF {
-		err = &LexingError{Line: r.line, Column: r.col, Err: err}
+	return r.errAt(r.line, r.col, err)
+}
+
+func (r *Reader) errAt(line, col int, err error) stateFunc {
+	if _, ok := err.(*LexingError); !ok && err != io.EOF {
+		err = &LexingError{Line: line, Column: col, Err: err}
+	}
+	if err == io.EOF && r.opts.autoClose {
+		r.autoClose()
 	}
 
 	var fn func(r *Reader) stateFunc
 	fn = func(r *Reader) stateFunc {
-		r.tokens <- token{typ: tokenError, err: err}
+		r.queue(token{typ: tokenError, err: err})
 		return fn
 	}
 	return fn
@@ -123,22 +312,78 @@ func (r *Reader) peek() (rune, error) {
 }
 
 func (r *Reader) pop() (rune, error) {
-	next, _, err := r.rd.ReadRune()
+	next, size, err := r.rd.ReadRune()
 	if err != nil {
 		return 0, err
 	}
+	r.offset += int64(size)
+	r.lastsize = size
+	r.lastnl = next == '\n'
 	if next == '\n' {
 		r.line++
 		r.lastcol, r.col = r.col, 0
 	} else {
 		r.lastcol, r.col = r.col, r.col + 1
 	}
+	if max := r.opts.maxSize; max > 0 && r.offset > max {
+		return 0, fmt.Errorf("input exceeds maximum size of %d bytes", max)
+	}
 	return next, nil
 }
 
 func (r *Reader) push() {
 	r.rd.UnreadRune()
 	r.col = r.lastcol
+	r.offset -= int64(r.lastsize)
+	if r.lastnl {
+		r.line--
+	}
+}
+
+// inKey returns whether the reader is positioned where an object key is
+// expected.
+func (r *Reader) inKey() bool {
+	return len(r.stack) > 0 && r.stack[len(r.stack)-1].kind == '{' && !r.noident
+}
+
+// beginKey records the start of an object key, which must be called right
+// after emitting its opening quote.
+func (r *Reader) beginKey() error {
+	top := &r.stack[len(r.stack)-1]
+	top.keys++
+	if max := r.opts.maxKeys; max > 0 && top.keys > max {
+		return fmt.Errorf("object exceeds maximum of %d keys", max)
+	}
+	if r.opts.rejectDuplicateKeys {
+		r.key = []rune{}
+		r.keyline, r.keycol = r.line, r.col
+	}
+	return nil
+}
+
+// endKey records the end of an object key, which must be called right
+// before emitting its closing quote.
+func (r *Reader) endKey() error {
+	if r.key == nil {
+		return nil
+	}
+	var key string
+	err := json.Unmarshal([]byte(`"`+string(r.key)+`"`), &key)
+	r.key = nil
+	if err != nil {
+		// Invalid escapes are reported by the JSON decoder.
+		return nil
+	}
+
+	top := &r.stack[len(r.stack)-1]
+	if top.seen[key] {
+		return &LexingError{Line: r.keyline, Column: r.keycol, Err: fmt.Errorf("duplicate key %q", key)}
+	}
+	if top.seen == nil {
+		top.seen = make(map[string]bool)
+	}
+	top.seen[key] = true
+	return nil
 }
 
 func (r *Reader) maybeEmitComma() {
@@ -149,15 +394,37 @@ func (r *Reader) maybeEmitComma() {
 }
 
 func (r *Reader) lex() stateFunc {
+	r.toklen = 0
+	r.mark()
 	b, err := r.pop()
 	if err != nil {
 		return r.err(err)
 	}
+	if r.colon && b != ':' && b != '/' && !unicode.IsSpace(b) {
+		return r.err(fmt.Errorf("unexpected character %q after key", b))
+	}
+	if r.inKey() && strings.ContainsRune("0123456789.+-", b) {
+		return r.lexNumericKey(b)
+	}
 	switch b {
 	case '"', '\'':
+		if r.opts.consistentQuotes {
+			if r.docquote == 0 {
+				r.docquote = b
+			} else if b != r.docquote {
+				return r.err(fmt.Errorf("string quoted with %q, but the document quotes strings with %q", b, r.docquote))
+			}
+		}
+		r.hold()
 		r.maybeEmitComma()
 		r.quote = b
+		r.strkey = r.inKey()
 		r.emit(tokenRune, '"')
+		if r.strkey {
+			if err := r.beginKey(); err != nil {
+				return r.err(err)
+			}
+		}
 		return (*Reader).lexString
 	case '/':
 		next, err := r.pop()
@@ -166,44 +433,72 @@ func (r *Reader) lex() stateFunc {
 		}
 		switch next {
 		case '/':
+			r.endScalar()
 			return (*Reader).lexLineComment
+		case '*':
+			r.endScalar()
+			return (*Reader).lexBlockComment
 		}
 		r.push()
 	case ',':
 		// omit all commas, we insert them ourselves
+		r.endScalar()
 		r.comma = true
 		r.noident = false
 	case '{', '[':
+		r.release()
 		r.maybeEmitComma()
 		r.noident = false
+		r.stack = append(r.stack, frame{kind: b})
+		if max := r.opts.maxDepth; max > 0 && len(r.stack) > max {
+			return r.err(fmt.Errorf("exceeded maximum nesting depth of %d", max))
+		}
 		r.emit(tokenRune, b)
 	case '}', ']':
+		r.release()
 		r.comma = false
 		r.noident = false
+		if len(r.stack) > 0 {
+			r.stack = r.stack[:len(r.stack)-1]
+		}
 		r.emit(tokenRune, b)
 	case '+':
 		// omit leading +
 	case '0': // either 0xabcd or 0.1234
+		r.holdScalar()
 		r.maybeEmitComma()
 		next, err := r.pop()
+		if err == io.EOF {
+			r.emit(tokenRune, b)
+		}
 		if err != nil {
 			return r.err(err)
 		}
 		if next == 'x' || next == 'X' {
 			return (*Reader).lexHex
 		}
+		if err := r.countToken(); err != nil {
+			return r.err(err)
+		}
 		r.emit(tokenRune, b)
 		r.push()
+		return (*Reader).lexNumber
 	case '.':
+		r.holdScalar()
 		r.maybeEmitComma()
+		if err := r.countToken(); err != nil {
+			return r.err(err)
+		}
 		r.emit(tokenRune, '0')
 		r.emit(tokenRune, '.')
 		return (*Reader).lexNumber
 	case ':':
 		r.noident = true
+		r.colon = false
 		r.emit(tokenRune, ':')
 	default:
 		if unicode.IsSpace(b) {
+			r.endScalar()
 			for unicode.IsSpace(b) {
 				b, err = r.pop()
 				if err != nil {
@@ -213,13 +508,29 @@ func (r *Reader) lex() stateFunc {
 			r.push()
 			return (*Reader).lex
 		}
-		r.maybeEmitComma()
-		if !r.noident && unicode.IsLetter(b) || b == '$' || b == '_' || b == '\\' {
+		if r.inKey() && (unicode.IsLetter(b) || b == '$' || b == '_' || b == '\\') {
+			if err := r.countString(); err != nil {
+				return r.err(err)
+			}
+			if err := r.countToken(); err != nil {
+				return r.err(err)
+			}
+			r.hold()
+			r.maybeEmitComma()
 			r.emit(tokenRune, '"')
+			if err := r.beginKey(); err != nil {
+				return r.err(err)
+			}
 			r.emit(tokenRune, b)
 			return (*Reader).lexIdentifier
 		}
-		if (b > '0' && b < '9') || b == '.' || b == '+' {
+		if r.scalar && r.track {
+			// Only the start of a keyword or number needs a mark.
+			r.marks = r.marks[:len(r.marks)-1]
+		}
+		r.holdScalar()
+		r.maybeEmitComma()
+		if (b > '0' && b <= '9') || b == '.' || b == '+' {
 			r.push()
 			return (*Reader).lexNumber
 		}
@@ -235,40 +546,92 @@ func (r *Reader) lexIdentifier() stateFunc {
 	}
 	// https://262.ecma-international.org/5.1/#sec-7.6
 	if unicode.In(b, unicode.L, unicode.Nl, unicode.Nd, unicode.Mn, unicode.Mc, unicode.Pc) || b == '$' || b == '_' || b == '\\' || b == '\u200C' || b == '\u200D' {
+		if err := r.countString(); err != nil {
+			return r.err(err)
+		}
+		if err := r.countToken(); err != nil {
+			return r.err(err)
+		}
 		r.emit(tokenRune, b)
 		return (*Reader).lexIdentifier
 	}
-	switch b {
-	case ':':
+	switch {
+	case b == ':' || unicode.IsSpace(b):
+		// The colon may follow after whitespace and comments, which lex
+		// skips like it does after quoted keys.
+		if err := r.endKey(); err != nil {
+			return r.err(err)
+		}
 		r.emit(tokenRune, '"')
+		r.colon = true
 		r.push()
 		return (*Reader).lex
+	case b == '/':
+		line, col := r.line, r.col
+		next, err := r.pop()
+		if err != nil {
+			return r.err(err)
+		}
+		if next != '/' && next != '*' {
+			return r.errAt(line, col, fmt.Errorf("unexpected character %q in identifier", b))
+		}
+		if err := r.endKey(); err != nil {
+			return r.err(err)
+		}
+		r.emit(tokenRune, '"')
+		r.colon = true
+		if next == '/' {
+			return (*Reader).lexLineComment
+		}
+		return (*Reader).lexBlockComment
 	default:
 		return r.err(fmt.Errorf("unexpected character %q in identifier", b))
 	}
 }
 
+// lexNumericKey rejects unquoted keys that start like a number, such as
+// 0x1f or 1e3. These are not valid identifiers, and translating them either
+// way would silently guess what the author meant.
+func (r *Reader) lexNumericKey(first rune) stateFunc {
+	line, col := r.line, r.col
+	key := []rune{first}
+	for {
+		b, err := r.pop()
+		if err != nil && err != io.EOF {
+			return r.err(err)
+		}
+		if err == io.EOF || unicode.IsSpace(b) || strings.ContainsRune(":,{}[]/", b) {
+			break
+		}
+		key = append(key, b)
+	}
+	return r.errAt(line, col, fmt.Errorf("ambiguous unquoted key %q: keys that look like numbers must be quoted", string(key)))
+}
+
 func (r *Reader) lexNumber() stateFunc {
 	b, err := r.pop()
 	if err != nil {
 		return r.err(err)
 	}
+	if strings.IndexRune("0123456789eE.+-", b) == -1 {
+		r.push()
+		return (*Reader).lex
+	}
+	if err := r.countToken(); err != nil {
+		return r.err(err)
+	}
 	if b == '.' {
 		next, err := r.pop()
 		if err != nil {
 			return r.err(err)
 		}
+		r.push()
+		r.emit(tokenRune, '.')
 		if strings.IndexRune("0123456789", next) == -1 {
-			r.push()
-			r.emit(tokenRune, '.')
 			r.emit(tokenRune, '0')
 		}
 		return (*Reader).lexNumber
 	}
-	if strings.IndexRune("0123456789eE.+-", b) == -1 {
-		r.push()
-		return (*Reader).lex
-	}
 	if b == 'e' || b == 'E' {
 		next, err := r.pop()
 		if err != nil {
@@ -295,12 +658,26 @@ func (r *Reader) lexHex() stateFunc {
 			break
 		}
 		out.WriteRune(b)
+		if max := r.opts.maxTokenLength; max > 0 && out.Len() > max {
+			return r.err(fmt.Errorf("token exceeds maximum length of %d", max))
+		}
+	}
+	if out.Len() == 0 {
+		return r.err(errors.New("hexadecimal number has no digits"))
 	}
 	val, err := strconv.ParseInt(out.String(), 16, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return r.err(fmt.Errorf("hexadecimal number 0x%v out of range", out.String()))
+	}
 	if err != nil {
 		panic("programming error: we lexed a non-hexadecimal number")
 	}
-	r.tokens <- token{typ: tokenNumber, num: strconv.FormatInt(val, 10)}
+	tok := token{typ: tokenNumber, num: strconv.FormatInt(val, 10)}
+	if r.holding {
+		r.held = append(r.held, tok)
+	} else {
+		r.queue(tok)
+	}
 	return (*Reader).lex
 }
 
@@ -309,9 +686,23 @@ func (r *Reader) lexString() stateFunc {
 	if err != nil {
 		return r.err(err)
 	}
+	if b != r.quote {
+		if err := r.countString(); err != nil {
+			return r.err(err)
+		}
+		if err := r.countToken(); err != nil {
+			return r.err(err)
+		}
+	}
 	switch b {
 	case r.quote:
+		if err := r.endKey(); err != nil {
+			return r.err(err)
+		}
 		r.emit(tokenRune, '"')
+		if !r.strkey {
+			r.release()
+		}
 		return (*Reader).lex
 	case '\n', '\r':
 		return r.err(errors.New("unexpected newline"))
@@ -320,10 +711,16 @@ func (r *Reader) lexString() stateFunc {
 		if err != nil {
 			return r.err(err)
 		}
+		if err := r.countToken(); err != nil {
+			return r.err(err)
+		}
 		r.emit(tokenRune, '\\')
 		if next == '\n' {
 			// support line-escaping for multiline strings
 			r.emit(tokenRune, 'n')
+			if !r.opts.preserveIndent {
+				return (*Reader).lexContinuation
+			}
 		} else {
 			r.emit(tokenRune, next)
 		}
@@ -331,13 +728,61 @@ func (r *Reader) lexString() stateFunc {
 		// This is only reached in single-quote mode, and therefore
 		// a double-quote in that context needs to be escaped.
 		r.emit(tokenRune, '\\')
-		fallthrough
+		r.emit(tokenRune, b)
+	case '\t':
+		r.emit(tokenRune, '\\')
+		r.emit(tokenRune, 't')
 	default:
+		if b < 0x20 {
+			// JSON does not allow raw control characters in strings.
+			for _, c := range fmt.Sprintf("\\u%04x", b) {
+				r.emit(tokenRune, c)
+			}
+			break
+		}
 		r.emit(tokenRune, b)
 	}
 	return (*Reader).lexString
 }
 
+// lexShebang skips the first line of the input if it starts with #!. It is
+// only ever the initial state.
+func (r *Reader) lexShebang() stateFunc {
+	b, err := r.pop()
+	if err != nil {
+		return r.err(err)
+	}
+	if b != '#' {
+		r.push()
+		return (*Reader).lex
+	}
+	next, err := r.pop()
+	if err != nil {
+		return r.err(err)
+	}
+	if next != '!' {
+		// Not a shebang; let the JSON decoder reject the stray #.
+		r.push()
+		r.emit(tokenRune, b)
+		return (*Reader).lex
+	}
+	return (*Reader).lexLineComment
+}
+
+// lexContinuation skips the indentation of a line continuing a string.
+func (r *Reader) lexContinuation() stateFunc {
+	for {
+		b, err := r.pop()
+		if err != nil {
+			return r.err(err)
+		}
+		if b != ' ' && b != '\t' {
+			r.push()
+			return (*Reader).lexString
+		}
+	}
+}
+
 func (r *Reader) lexLineComment() stateFunc {
 	for {
 		b, err := r.pop()
@@ -350,6 +795,23 @@ func (r *Reader) lexLineComment() stateFunc {
 	}
 }
 
+func (r *Reader) lexBlockComment() stateFunc {
+	var prev rune
+	for {
+		b, err := r.pop()
+		if err == io.EOF {
+			return r.err(errors.New("unterminated block comment"))
+		}
+		if err != nil {
+			return r.err(err)
+		}
+		if prev == '*' && b == '/' {
+			return (*Reader).lex
+		}
+		prev = b
+	}
+}
+
 type token struct {
 	typ tokenType
 	val rune
@@ -357,6 +819,16 @@ type token struct {
 	err error
 }
 
+func (t token) size() int64 {
+	switch t.typ {
+	case tokenRune:
+		return int64(utf8.RuneLen(t.val))
+	case tokenNumber:
+		return int64(len(t.num))
+	}
+	return 0
+}
+
 type tokenType int
 
 const (
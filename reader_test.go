@@ -1,12 +1,15 @@
 package json5
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"io"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestReaderValid(t *testing.T) {
@@ -193,6 +196,81 @@ No \\n's!",
 			}
 			`,
 		},
+		{
+			In:  `/* header */ { "a": 1 }`,
+			Out: `{ "a": 1 }`,
+		},
+		{
+			In:  `/* header */ [1, 2, 3]`,
+			Out: `[1, 2, 3]`,
+		},
+		{
+			In:  `/* header */ 42`,
+			Out: `42`,
+		},
+		{
+			In: `/*
+			 * a multiline header,
+			 * with a ** few * stars */
+			'hello'`,
+			Out: `"hello"`,
+		},
+		{
+			In:  "// note\n{ a: 1 }",
+			Out: `{ "a": 1 }`,
+		},
+		{
+			In:  "// note\n[1, 2, 3]",
+			Out: `[1, 2, 3]`,
+		},
+		{
+			In:  "// note\n42",
+			Out: `42`,
+		},
+		{
+			In:  "// note\n0",
+			Out: `0`,
+		},
+		{
+			In:  "/* note */ true",
+			Out: `true`,
+		},
+		{
+			In:  `[/* first */ true, /* second */ null]`,
+			Out: `[true, null]`,
+		},
+		{
+			In:  "{ tab: 'a\tb', bell: '\a' }",
+			Out: `{ "tab": "a\tb", "bell": "\u0007" }`,
+		},
+		{
+			In:  `{a: null, b: [null,], c: null,}`,
+			Out: `{"a": null, "b": [null], "c": null}`,
+		},
+		{
+			In:  `[null, /* comment */ null,]`,
+			Out: `[null, null]`,
+		},
+		{
+			In:  `{ "0x1f": 0x1f, '1e3': 1e3 }`,
+			Out: `{ "0x1f": 31, "1e3": 1000 }`,
+		},
+		{
+			In:  `{ a: 1.5, b: 0.25, c: 9.75, d: .5, e: 5., f: 19 }`,
+			Out: `{ "a": 1.5, "b": 0.25, "c": 9.75, "d": 0.5, "e": 5.0, "f": 19 }`,
+		},
+		{
+			In:  `{ key /* note */ : "value", "quoted" /* note */ : 1 }`,
+			Out: `{ "key": "value", "quoted": 1 }`,
+		},
+		{
+			In:  `{ key : /* note */ "value", other:/* note */1 }`,
+			Out: `{ "key": "value", "other": 1 }`,
+		},
+		{
+			In:  "{ key // note\n\t: 'value', other/* note */:\n// note\n1 }",
+			Out: `{ "key": "value", "other": 1 }`,
+		},
 	}
 
 	for i, tc := range tcases {
@@ -212,3 +290,424 @@ No \\n's!",
 		})
 	}
 }
+
+func TestReaderInvalid(t *testing.T) {
+
+	tcases := []struct{
+		In, Err string
+		Opts    []Option
+	}{
+		{
+			In:  `{ "a": 1 } /* unterminated`,
+			Err: "json5: at line 1 column 26: unterminated block comment",
+		},
+		{
+			In:  `{0x1f: 1}`,
+			Err: `json5: at line 1 column 2: ambiguous unquoted key "0x1f": keys that look like numbers must be quoted`,
+		},
+		{
+			In: `
+{
+  a: 1,
+  1e3: 2,
+}`,
+			Err: `json5: at line 4 column 3: ambiguous unquoted key "1e3": keys that look like numbers must be quoted`,
+		},
+		{
+			In:  `{.5:1}`,
+			Err: `json5: at line 1 column 2: ambiguous unquoted key ".5": keys that look like numbers must be quoted`,
+		},
+		{
+			In:  "{\n  a: 1\n,\n  b c: 1 }",
+			Err: `json5: at line 4 column 5: unexpected character 'c' after key`,
+		},
+		{
+			In:  `{ a /* note */ b: 1 }`,
+			Err: `json5: at line 1 column 16: unexpected character 'b' after key`,
+		},
+		{
+			In:  `{ a/b: 1 }`,
+			Err: `json5: at line 1 column 4: unexpected character '/' in identifier`,
+		},
+		{
+			In:  `[0x]`,
+			Err: `json5: at line 1 column 3: hexadecimal number has no digits`,
+		},
+		{
+			In:  `[0x10000000000000000]`,
+			Err: `json5: at line 1 column 20: hexadecimal number 0x10000000000000000 out of range`,
+		},
+		{
+			In:   `{ a: [[1]], b: [[[2]]] }`,
+			Err:  `json5: at line 1 column 18: exceeded maximum nesting depth of 3`,
+			Opts: []Option{WithMaxDepth(3)},
+		},
+		{
+			In:   `{ a: 'hello' }`,
+			Err:  `json5: at line 1 column 11: input exceeds maximum size of 10 bytes`,
+			Opts: []Option{WithMaxSize(10)},
+		},
+		{
+			In:   `[{ a: 1, b: 2 }, { a: 1, b: 2, c: 3 }]`,
+			Err:  `json5: at line 1 column 32: object exceeds maximum of 2 keys`,
+			Opts: []Option{WithMaxKeys(2)},
+		},
+		{
+			In:   `['short', 'too long!']`,
+			Err:  `json5: at line 1 column 20: token exceeds maximum length of 8`,
+			Opts: []Option{WithMaxTokenLength(8)},
+		},
+		{
+			In:   `[0xffff, 0xfffff]`,
+			Err:  `json5: at line 1 column 16: token exceeds maximum length of 4`,
+			Opts: []Option{WithMaxTokenLength(4)},
+		},
+		{
+			In: `
+{
+  a: 1,
+  "b": { a: 2 },
+  'a': 3,
+}`,
+			Err:  `json5: at line 5 column 3: duplicate key "a"`,
+			Opts: []Option{WithRejectDuplicateKeys()},
+		},
+		{
+			In:   `{ "\u0061": 1, a: 2 }`,
+			Err:  `json5: at line 1 column 16: duplicate key "a"`,
+			Opts: []Option{WithRejectDuplicateKeys()},
+		},
+		{
+			In:   `{ a: 'x', b: "y" }`,
+			Err:  `json5: at line 1 column 14: string quoted with '"', but the document quotes strings with '\''`,
+			Opts: []Option{WithConsistentQuotes()},
+		},
+		{
+			In: `{
+  'a': 1,
+  b: ['x', 'y', "z"],
+}`,
+			Err:  `json5: at line 3 column 17: string quoted with '"', but the document quotes strings with '\''`,
+			Opts: []Option{WithConsistentQuotes()},
+		},
+	}
+
+	for i, tc := range tcases {
+		t.Run(strconv.Itoa(i), func (t *testing.T) {
+			_, err := io.ReadAll(NewReader(strings.NewReader(tc.In), tc.Opts...))
+			if err == nil {
+				t.Fatalf("expected error %q, got none", tc.Err)
+			}
+			if err.Error() != tc.Err {
+				t.Fatalf("expected error %q, got %q", tc.Err, err.Error())
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	in := []byte(`
+	{
+		// a comment that does not make it into the checksum
+		unquoted: 'single quotes and unicode: ☃',
+		hex: 0xdecaf,
+		list: [1, 2, 3,],
+	}
+	`)
+
+	out, err := ToJSON(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := sha256.Sum256(out)
+
+	h := sha256.New()
+	if err := Checksum(in, h); err != nil {
+		t.Fatal(err)
+	}
+	if actual := h.Sum(nil); !bytes.Equal(expected[:], actual) {
+		t.Fatalf("expected checksum %x, got %x (translated json: %s)", expected, actual, out)
+	}
+
+	// Reading a byte at a time must produce the same output as WriteTo,
+	// even when multi-byte runes are split across reads.
+	small, err := io.ReadAll(iotest.OneByteReader(NewReader(bytes.NewReader(in))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, small) {
+		t.Fatalf("expected %s, got %s", out, small)
+	}
+}
+
+func TestReaderStats(t *testing.T) {
+
+	tcases := []struct{
+		In          string
+		Source, JSON int64
+	}{
+		{
+			// comments make the source larger than the JSON
+			In:     "// a comment\n[1, /* another */ 2]",
+			Source: 33,
+			JSON:   5,
+		},
+		{
+			// unquoted keys make the JSON larger than the source
+			In:     `{a:1,b:2}`,
+			Source: 9,
+			JSON:   13,
+		},
+	}
+
+	for i, tc := range tcases {
+		t.Run(strconv.Itoa(i), func (t *testing.T) {
+			rd := NewReader(strings.NewReader(tc.In))
+			out, err := io.ReadAll(rd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			source, json := rd.Stats()
+			if source != tc.Source || json != tc.JSON {
+				t.Fatalf("expected %v source and %v json bytes, got %v and %v (translated json: %s)", tc.Source, tc.JSON, source, json, out)
+			}
+			if json != int64(len(out)) {
+				t.Fatalf("reported %v json bytes, but read %v", json, len(out))
+			}
+		})
+	}
+}
+
+func TestReaderAutoClose(t *testing.T) {
+
+	tcases := []struct{
+		In, Out string
+	}{
+		{
+			In:  `{ "a": 1, "b":`,
+			Out: `{ "a": 1 }`,
+		},
+		{
+			In:  `{ "a": 1, "b"`,
+			Out: `{ "a": 1 }`,
+		},
+		{
+			In:  `{ a: 1, b`,
+			Out: `{ "a": 1 }`,
+		},
+		{
+			In:  `{ a: 1, `,
+			Out: `{ "a": 1 }`,
+		},
+		{
+			In:  `{ a: 1, b: 'unterminated`,
+			Out: `{ "a": 1 }`,
+		},
+		{
+			// 2 might have been the start of a longer number.
+			In:  `{ a: [1, 2`,
+			Out: `{ "a": [1] }`,
+		},
+		{
+			In:  `{ a: [1, 2 `,
+			Out: `{ "a": [1, 2] }`,
+		},
+		{
+			In:  "[1, 2 // comment",
+			Out: `[1, 2]`,
+		},
+		{
+			In:  `{ a: 1, b: tru`,
+			Out: `{ "a": 1 }`,
+		},
+		{
+			In:  `{ a: 1, b: -`,
+			Out: `{ "a": 1 }`,
+		},
+		{
+			In:  `[1, nul`,
+			Out: `[1]`,
+		},
+		{
+			In:  `{ a: 1, b: 1e`,
+			Out: `{ "a": 1 }`,
+		},
+		{
+			In:  `{ a: 1, b: 0x1f`,
+			Out: `{ "a": 1 }`,
+		},
+		{
+			In: `{
+				a: { b: true, c: null },
+				d: [{ e: 'f' }, 'g', 'h`,
+			Out: `{ "a": { "b": true, "c": null }, "d": [{ "e": "f" }, "g"] }`,
+		},
+	}
+
+	for i, tc := range tcases {
+		t.Run(strconv.Itoa(i), func (t *testing.T) {
+			var expected, actual interface{}
+			json.Unmarshal([]byte(tc.Out), &expected)
+			err := NewDecoder(strings.NewReader(tc.In), WithAutoClose()).Decode(&actual)
+			if err != nil {
+				txt, _ := io.ReadAll(NewReader(strings.NewReader(tc.In), WithAutoClose()))
+				t.Fatalf("error %v (translated json: %v)", err, string(txt))
+			}
+
+			if !reflect.DeepEqual(expected, actual) {
+				txt, _ := io.ReadAll(NewReader(strings.NewReader(tc.In), WithAutoClose()))
+				t.Fatalf("expected %v, got %v (translated json: %v)", expected, actual, string(txt))
+			}
+
+			// Without the option, truncated documents are errors.
+			if err := Unmarshal([]byte(tc.In), &actual); err == nil {
+				t.Fatal("expected an error without WithAutoClose")
+			}
+		})
+	}
+}
+
+func TestStripComments(t *testing.T) {
+	in := `// leading comment
+{
+	"url": "http://example.com", // a comment after a string with //
+	'quote': 'this /* is not */ a comment',
+	"escaped quote": "say \"// no comment\"",
+	/* a block
+	   comment spanning ☃ lines */ num: 1,
+	"escaped": "\\", // backslash at the end of a string
+}
+/* trailing */`
+
+	out, err := StripComments([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out) != len(in) {
+		t.Fatalf("expected %v bytes, got %v", len(in), len(out))
+	}
+	if strings.Count(string(out), "\n") != strings.Count(in, "\n") {
+		t.Fatalf("expected line count to be preserved, got:\n%s", out)
+	}
+	for i := range out {
+		if out[i] != in[i] && out[i] != ' ' {
+			t.Fatalf("unexpected change at byte %v, got:\n%s", i, out)
+		}
+	}
+	for _, kept := range []string{`"http://example.com"`, `'this /* is not */ a comment'`, `"say \"// no comment\""`, `num: 1,`, `"\\",`} {
+		if !strings.Contains(string(out), kept) {
+			t.Fatalf("expected %s to be kept, got:\n%s", kept, out)
+		}
+	}
+	for _, removed := range []string{"leading", "with //", "block", "spanning", "backslash", "trailing"} {
+		if strings.Contains(string(out), removed) {
+			t.Fatalf("expected %q to be removed, got:\n%s", removed, out)
+		}
+	}
+
+	var expected, actual interface{}
+	if err := Unmarshal([]byte(in), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if err := Unmarshal(out, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+
+	_, err = StripComments([]byte("{\n  a: 1, /* unterminated\n}"))
+	if err == nil || err.Error() != "json5: at line 2 column 9: unterminated block comment" {
+		t.Fatalf("expected an unterminated comment error, got %v", err)
+	}
+}
+
+func TestReaderShebang(t *testing.T) {
+	in := "#!/usr/bin/env myconfig --json5\n{\n  // comment\n  name: 'server',\n}\n"
+
+	var actual interface{}
+	if err := NewDecoder(strings.NewReader(in), WithShebang()).Decode(&actual); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]interface{}{"name": "server"}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+
+	// Lines still count from the shebang.
+	_, err := io.ReadAll(NewReader(strings.NewReader("#!/bin/sh\n{ /* unterminated"), WithShebang()))
+	if err == nil || err.Error() != "json5: at line 2 column 17: unterminated block comment" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Only the very first bytes may start a shebang.
+	for _, in := range []string{" #!/bin/sh\n{}", "\n#!/bin/sh\n{}", "#/bin/sh\n{}"} {
+		if err := NewDecoder(strings.NewReader(in), WithShebang()).Decode(&actual); err == nil {
+			t.Fatalf("expected an error decoding %q", in)
+		}
+	}
+
+	// Without the option, shebangs are errors.
+	if err := Unmarshal([]byte(in), &actual); err == nil {
+		t.Fatal("expected an error without WithShebang")
+	}
+}
+
+func TestReaderMaxTokenLength(t *testing.T) {
+	// Each token has exactly 8 runes of content, which is accepted; one
+	// more is not. Quotes and escapes added by the translation do not count.
+	tcases := []struct {
+		In, Longer string
+	}{
+		{In: `'abcdefgh'`, Longer: `'abcdefghi'`},
+		{In: `'a"bcdefg'`, Longer: `'a"bcdefgh'`},
+		{In: "'a\tbcdefg'", Longer: "'a\tbcdefgh'"},
+		{In: `{ abcdefgh: 1 }`, Longer: `{ abcdefghi: 1 }`},
+		{In: `12345678`, Longer: `123456789`},
+		{In: `-1234.5e6`, Longer: `-1234.5e67`},
+		{In: `.1234567`, Longer: `.12345678`},
+		{In: `0xffffffff`, Longer: `0xfffffffff`},
+	}
+
+	for _, tc := range tcases {
+		if _, err := io.ReadAll(NewReader(strings.NewReader(tc.In), WithMaxTokenLength(8))); err != nil {
+			t.Errorf("%s: unexpected error %v", tc.In, err)
+		}
+		_, err := io.ReadAll(NewReader(strings.NewReader(tc.Longer), WithMaxTokenLength(8)))
+		if err == nil || !strings.Contains(err.Error(), "token exceeds maximum length of 8") {
+			t.Errorf("%s: expected the token to be too long, got %v", tc.Longer, err)
+		}
+	}
+}
+
+func TestReaderContinuationIndent(t *testing.T) {
+	in := "{\n\tmessage: 'hello, \\\n\t\tworld, \\\n\t\t  and \\\n\t\tgoodbye',\n}"
+
+	tcases := []struct{
+		Opts     []Option
+		Expected string
+	}{
+		{
+			// trimmed by default
+			Expected: "hello, \nworld, \nand \ngoodbye",
+		},
+		{
+			Opts:     []Option{WithPreserveIndent()},
+			Expected: "hello, \n\t\tworld, \n\t\t  and \n\t\tgoodbye",
+		},
+	}
+
+	for i, tc := range tcases {
+		t.Run(strconv.Itoa(i), func (t *testing.T) {
+			var actual struct {
+				Message string
+			}
+			if err := NewDecoder(strings.NewReader(in), tc.Opts...).Decode(&actual); err != nil {
+				t.Fatal(err)
+			}
+			if actual.Message != tc.Expected {
+				t.Fatalf("expected %q, got %q", tc.Expected, actual.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package json5
+
+import (
+	"errors"
+)
+
+// StripComments returns a copy of the JSON5 document in src where every
+// comment has been replaced by spaces, one per byte. Line breaks within
+// comments are kept, so that everything else stays at the same byte offset
+// and line. Columns, which count runes, stay the same too unless a comment
+// before them on the line contains multi-byte runes.
+//
+// Unlike the Reader, StripComments leaves the document as JSON5.
+func StripComments(src []byte) ([]byte, error) {
+	out := make([]byte, len(src))
+	copy(out, src)
+
+	var quote byte
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; {
+		case quote != 0:
+			switch c {
+			case '\\':
+				i++
+			case quote, '\n':
+				// Strings cannot span lines unescaped; stop there so
+				// that an unbalanced quote does not hide comments.
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for ; i < len(src) && src[i] != '\n'; i++ {
+				blank(out, i)
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			start := i
+			blank(out, i)
+			blank(out, i+1)
+			for i += 2; ; i++ {
+				if i >= len(src) {
+					line, col := lineColumn(src, start)
+					return nil, &LexingError{Line: line, Column: col, Err: errors.New("unterminated block comment")}
+				}
+				if src[i] == '*' && i+1 < len(src) && src[i+1] == '/' {
+					blank(out, i)
+					blank(out, i+1)
+					i++
+					break
+				}
+				blank(out, i)
+			}
+		}
+	}
+	return out, nil
+}
+
+// blank replaces the comment byte at i with a space, unless it breaks lines.
+func blank(out []byte, i int) {
+	if out[i] != '\n' && out[i] != '\r' {
+		out[i] = ' '
+	}
+}
+
+// lineColumn returns the line and column of the byte at offset in src,
+// counting columns in runes like the Reader does.
+func lineColumn(src []byte, offset int) (line, col int) {
+	line, col = 1, 1
+	for _, c := range string(src[:offset]) {
+		if c == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}